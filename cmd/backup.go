@@ -3,6 +3,8 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
 
 	"excelDisclaimer/internal/backup"
 	"excelDisclaimer/internal/database"
@@ -14,19 +16,27 @@ var (
 	outputDir        string
 	backupFormat     string
 	backupCollection string
+	backupCompress   string
+	backupJSONMode   string
+	backupLayout     string
+	backupArchive    bool
 )
 
 var backupCmd = &cobra.Command{
 	Use:   "backup",
 	Short: "Backup MongoDB collections",
-	Long:  "Backup MongoDB collections to BSON or JSON files",
+	Long:  "Backup MongoDB collections to BSON or JSON files, written as a set with a manifest.json, or in the mongodump directory or archive layout",
 	RunE:  runBackup,
 }
 
 func init() {
 	backupCmd.Flags().StringVarP(&outputDir, "output", "o", "./backups", "Output directory for backup files")
 	backupCmd.Flags().StringVarP(&backupFormat, "format", "f", "bson", "Backup format: bson or json")
-	backupCmd.Flags().StringVarP(&backupCollection, "collection", "c", "", "Specific collection to backup (if empty, backs up all collections)")
+	backupCmd.Flags().StringVar(&backupJSONMode, "json-mode", database.JSONCanonical, "Extended JSON mode for the json format: canonical (exact types) or relaxed (more readable)")
+	backupCmd.Flags().StringVar(&backupCompress, "compress", backup.CompressNone, "Compress backup files: none, gzip or zstd")
+	backupCmd.Flags().StringVar(&backupLayout, "layout", backup.LayoutSet, "Backup layout: set or mongodump (<db>/<collection>.bson, readable by mongorestore)")
+	backupCmd.Flags().BoolVar(&backupArchive, "archive", false, "With --layout mongodump, write a single mongodump --archive file instead of a directory")
+	backupCmd.Flags().StringVarP(&backupCollection, "collection", "c", "", "Collections to backup, comma-separated (if empty, backs up all collections)")
 	backupCmd.Flags().StringVarP(&dbURI, "db-uri", "u", "mongodb://localhost:27017", "MongoDB connection URI")
 	backupCmd.Flags().StringVarP(&dbName, "database", "d", "csvprocessor", "Database name")
 }
@@ -36,6 +46,18 @@ func runBackup(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid format: %s. Use 'bson' or 'json'", backupFormat)
 	}
 
+	if err := backup.ValidateCompression(backupCompress); err != nil {
+		return err
+	}
+
+	if backupJSONMode != database.JSONCanonical && backupJSONMode != database.JSONRelaxed {
+		return fmt.Errorf("invalid JSON mode: %s. Use 'canonical' or 'relaxed'", backupJSONMode)
+	}
+
+	if err := backup.ValidateLayout(backupLayout, backupFormat, backupCompress, backupArchive); err != nil {
+		return err
+	}
+
 	db, err := database.NewMongoDB(dbURI, dbName)
 	if err != nil {
 		return fmt.Errorf("failed to connect to MongoDB: %w", err)
@@ -44,25 +66,60 @@ func runBackup(cmd *cobra.Command, args []string) error {
 
 	backupService := backup.NewService(db)
 
-	if backupCollection != "" {
-		log.Printf("Starting backup of collection '%s' to %s format...", backupCollection, backupFormat)
-		backupFile, err := backupService.BackupCollection(backupCollection, outputDir, backupFormat)
-		if err != nil {
-			return fmt.Errorf("backup failed: %w", err)
-		}
-		log.Printf("Backup completed successfully: %s", backupFile)
+	collections := splitList(backupCollection)
+	if len(collections) > 0 {
+		log.Printf("Starting backup of %s from database '%s' to %s format...", strings.Join(collections, ", "), dbName, backupFormat)
 	} else {
 		log.Printf("Starting backup of all collections in database '%s' to %s format...", dbName, backupFormat)
-		backupFiles, err := backupService.BackupDatabase(outputDir, backupFormat)
-		if err != nil {
-			return fmt.Errorf("backup failed: %w", err)
-		}
-		
-		log.Printf("Backup completed successfully. Created %d backup files:", len(backupFiles))
-		for _, file := range backupFiles {
-			log.Printf("  - %s", file)
+	}
+
+	manifestPath, manifest, err := backupService.BackupDatabase(outputDir, backup.Options{
+		Format:      backupFormat,
+		Compression: backupCompress,
+		JSONMode:    backupJSONMode,
+		Layout:      backupLayout,
+		Archive:     backupArchive,
+	}, collections)
+	if err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+
+	if backupArchive {
+		log.Printf("Backup completed successfully. Archived %d collections:", len(manifest.Collections))
+		for _, entry := range manifest.Collections {
+			log.Printf("  - %s (%d documents)", entry.Collection, entry.Documents)
 		}
+		log.Printf("Archive: %s (%d bytes); restore it with: mongorestore --archive=%s%s", manifestPath, manifest.Collections[0].Bytes, manifestPath, gzipFlag(backupCompress))
+		return nil
+	}
+
+	log.Printf("Backup completed successfully. Created %d backup files:", len(manifest.Collections))
+	for _, entry := range manifest.Collections {
+		log.Printf("  - %s (%d documents, %d bytes)", entry.File, entry.Documents, entry.Bytes)
+	}
+	log.Printf("Manifest: %s", manifestPath)
+	if backupLayout == backup.LayoutMongodump {
+		log.Printf("Restore it with: mongorestore --dir=%s%s", filepath.Dir(manifestPath), gzipFlag(backupCompress))
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// gzipFlag returns the mongorestore flag matching a compression
+func gzipFlag(compression string) string {
+	if compression == backup.CompressGzip {
+		return " --gzip"
+	}
+	return ""
+}
+
+// splitList parses a comma-separated flag value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
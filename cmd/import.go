@@ -3,44 +3,231 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
+	"excelDisclaimer/internal/autoselect"
+	"excelDisclaimer/internal/checkpoint"
 	"excelDisclaimer/internal/csv"
 	"excelDisclaimer/internal/database"
+	"excelDisclaimer/internal/models"
+	"excelDisclaimer/internal/normalize"
+	"excelDisclaimer/internal/validation"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	csvFile    string
-	dbURI      string
-	dbName     string
-	collection string
+	csvFile       string
+	xlsxFile      string
+	sheetName     string
+	dbURI         string
+	dbName        string
+	collection    string
+	batchSize     int
+	ordered       bool
+	dryRun        bool
+	mappingFile   string
+	keepExtra     bool
+	extraField    string
+	encoding      string
+	delimiter     string
+	comment       string
+	quoteMode     string
+	rulesFile     string
+	rejectsFile   string
+	onDuplicate   string
+	keyFields     string
+	syncMode      bool
+	missingPolicy string
+
+	autoSelectRules     string
+	autoSelectDefault   string
+	autoSelectOverwrite bool
+
+	snapshot    bool
+	snapshotDir string
+
+	resume        bool
+	checkpointDir string
+
+	atomic          bool
+	transactionSize int
+	maxFailures     int
+
+	numberPad   int
+	numberSci   bool
+	numberStrip string
+	numberCase  string
 )
 
 var importCmd = &cobra.Command{
 	Use:   "import",
-	Short: "Import CSV data to MongoDB",
-	Long:  "Import CSV data to MongoDB collection with Number field matching",
-	RunE:  runImport,
+	Short: "Import CSV or Excel data to MongoDB",
+	Long: `Import CSV or .xlsx data to MongoDB collection, matching documents on Number or a configurable key.
+
+Number normalization (--number-pad, --number-expand-sci, --number-strip,
+--number-case) matches documents stored before it was enabled by loading
+the key of every stored document into memory when the import starts, so
+it costs a full collection scan and memory proportional to its size.`,
+	RunE: runImport,
 }
 
 func init() {
-	importCmd.Flags().StringVarP(&csvFile, "csv", "c", "", "CSV file to import (required)")
+	importCmd.Flags().StringVarP(&csvFile, "csv", "c", "", "CSV file to import")
+	importCmd.Flags().StringVar(&xlsxFile, "xlsx", "", "Excel workbook (.xlsx) to import instead of a CSV file")
+	importCmd.Flags().StringVar(&sheetName, "sheet", "", "Worksheet name or 1-based index to read from --xlsx (defaults to the first sheet)")
 	importCmd.Flags().StringVarP(&dbURI, "db-uri", "u", "mongodb://localhost:27017", "MongoDB connection URI")
 	importCmd.Flags().StringVarP(&dbName, "database", "d", "csvprocessor", "Database name")
 	importCmd.Flags().StringVarP(&collection, "collection", "t", "records", "Collection name")
-	
-	importCmd.MarkFlagRequired("csv")
+	importCmd.Flags().IntVar(&batchSize, "batch-size", 500, "Number of records sent per BulkWrite")
+	importCmd.Flags().StringVar(&encoding, "encoding", csv.EncodingAuto, "CSV character encoding (auto, utf-8, utf-16le, utf-16be, windows-1252, ...)")
+	importCmd.Flags().StringVar(&delimiter, "delimiter", "", "CSV field delimiter: a character or comma, semicolon, tab, pipe (detected from the header if not specified)")
+	importCmd.Flags().StringVar(&comment, "comment", "", "Skip CSV lines starting with this character")
+	importCmd.Flags().StringVar(&quoteMode, "quote-mode", csv.QuoteLazy, "CSV quote handling: strict or lazy")
+	importCmd.Flags().StringVar(&mappingFile, "mapping", "", "YAML file mapping CSV columns to document fields")
+	importCmd.Flags().BoolVar(&keepExtra, "keep-extra", false, "Keep unmapped CSV columns as document fields")
+	importCmd.Flags().StringVar(&extraField, "extra-field", "", "Store kept extra columns in this sub-document instead of at the top level")
+	importCmd.Flags().StringVar(&rulesFile, "rules", "", "YAML file with per-field validation rules")
+	importCmd.Flags().StringVar(&rejectsFile, "rejects", "", "Write skipped and failed rows to this CSV file")
+	importCmd.Flags().StringVar(&keyFields, "key", "", "Comma-separated fields that identify a document, e.g. Number,Product (default Number, or the mapping file's key)")
+	importCmd.Flags().StringVar(&onDuplicate, "on-duplicate", duplicateLast, "How to handle a match key repeated in the file: first, last, error or merge")
+	importCmd.Flags().BoolVar(&syncMode, "sync", false, "Make the collection mirror the file by handling documents whose key is not in it")
+	importCmd.Flags().StringVar(&missingPolicy, "missing", missingReport, "With --sync, what to do with documents missing from the file: archive, delete or report")
+	importCmd.Flags().StringVar(&autoSelectRules, "auto-select-rules", "", "YAML file with rules computing AutoSelect from Product or Number")
+	importCmd.Flags().StringVar(&autoSelectDefault, "auto-select-default", "", "AutoSelect value for records without a CSV value or matching rule")
+	importCmd.Flags().BoolVar(&autoSelectOverwrite, "auto-select-overwrite", false, "Replace AutoSelect values already set on stored documents")
+	importCmd.Flags().BoolVar(&snapshot, "snapshot", false, "Back up the target collection before writing and print the command that undoes the import")
+	importCmd.Flags().StringVar(&snapshotDir, "snapshot-dir", "./backups", "Directory for --snapshot backups")
+	importCmd.Flags().BoolVar(&resume, "resume", false, "Continue an interrupted import of the same file from its last checkpoint")
+	importCmd.Flags().StringVar(&checkpointDir, "checkpoint-dir", "./.checkpoints", "Directory holding import checkpoints, one per file checksum")
+	importCmd.Flags().BoolVar(&atomic, "atomic", false, "Write the import in transactions so a failure rolls back the open one (requires a replica set)")
+	importCmd.Flags().IntVar(&transactionSize, "transaction-size", 10000, "With --atomic, commit the open transaction every this many rows, or every 30s if that comes first")
+	importCmd.Flags().IntVar(&maxFailures, "max-failures", 0, "Abort the import once this many rows are skipped or fail (0 means no limit). Rows written by earlier batches are kept; with --atomic only the open transaction is rolled back")
+	importCmd.Flags().IntVar(&numberPad, "number-pad", 0, "Left-pad all-digit Numbers with zeros to this width")
+	importCmd.Flags().BoolVar(&numberSci, "number-expand-sci", false, "Expand Numbers in scientific notation (1.23457E+11) to plain digits")
+	importCmd.Flags().StringVar(&numberStrip, "number-strip", "", "Characters to strip from Numbers, e.g. \" -\" (a space strips all whitespace)")
+	importCmd.Flags().StringVar(&numberCase, "number-case", "", "Fold Numbers to upper or lower case")
+	importCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what the import would change without writing anything")
+	importCmd.Flags().BoolVar(&ordered, "ordered", true, "Use ordered bulk writes (stop a batch at its first error)")
+
+	importCmd.MarkFlagsOneRequired("csv", "xlsx")
+	importCmd.MarkFlagsMutuallyExclusive("csv", "xlsx")
 }
 
-func runImport(cmd *cobra.Command, args []string) error {
-	parser := csv.NewParser(csvFile)
-	records, err := parser.ParseRecords()
+func runImport(cmd *cobra.Command, args []string) (err error) {
+	if batchSize <= 0 {
+		return fmt.Errorf("invalid batch size: %d", batchSize)
+	}
+
+	if err := validateDuplicatePolicy(onDuplicate); err != nil {
+		return err
+	}
+
+	if transactionSize <= 0 {
+		return fmt.Errorf("invalid transaction size: %d", transactionSize)
+	}
+	if maxFailures < 0 {
+		return fmt.Errorf("invalid max failures: %d", maxFailures)
+	}
+
+	if err := validateMissingPolicy(missingPolicy); err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("missing") && !syncMode {
+		return fmt.Errorf("--missing requires --sync")
+	}
+
+	var mapping *csv.Mapping
+	if mappingFile != "" {
+		loaded, err := csv.LoadMapping(mappingFile)
+		if err != nil {
+			return err
+		}
+		mapping = loaded
+	}
+
+	// matchKey is the set of fields identifying a stored document, from
+	// --key or the mapping file
+	matchKey := models.DefaultMatchKey()
+	if mapping != nil && len(mapping.Key) > 0 {
+		matchKey = mapping.Key
+	}
+	if keyFields != "" {
+		key, err := models.ParseMatchKey(keyFields)
+		if err != nil {
+			return fmt.Errorf("invalid --key: %w", err)
+		}
+		matchKey = key
+	}
+	if mapping != nil {
+		if err := mapping.CheckKey(matchKey); err != nil {
+			return fmt.Errorf("mapping %s cannot be used with key %s: %w", mappingFile, strings.Join(matchKey, ","), err)
+		}
+	}
+
+	parserOpts, err := parserOptions(mapping)
+	if err != nil {
+		return err
+	}
+
+	validator := validation.DefaultValidator(matchKey)
+	if rulesFile != "" {
+		loaded, err := validation.LoadRules(rulesFile, matchKey)
+		if err != nil {
+			return err
+		}
+		validator = loaded
+	}
+
+	autoSelect, err := autoselect.NewResolver(nil, autoSelectDefault)
 	if err != nil {
-		return fmt.Errorf("failed to parse CSV: %w", err)
+		return err
+	}
+	if autoSelectRules != "" {
+		autoSelect, err = autoselect.LoadRules(autoSelectRules, autoSelectDefault)
+		if err != nil {
+			return err
+		}
+	}
+
+	source := csvFile
+	if xlsxFile != "" {
+		source = xlsxFile
+	}
+	parser := csv.NewParser(source, parserOpts...)
+
+	// stream runs one pass over the input; the duplicate scan uses a quiet
+	// parser so the header report is only logged once
+	stream := func(p *csv.Parser) rowStreamer {
+		return func(fn func(csv.Row) error) error {
+			if xlsxFile != "" {
+				return p.StreamSheet(sheetName, fn)
+			}
+			return p.StreamRecords(fn)
+		}
+	}
+
+	checksum, err := fileSHA256(source)
+	if err != nil {
+		return err
+	}
+	settings, err := checkpointSettings(matchKey)
+	if err != nil {
+		return err
+	}
+	resumeAfter := 0
+	if resume {
+		resumeAfter, err = resumePoint(source, checksum, settings)
+		if err != nil {
+			return err
+		}
 	}
 
-	log.Printf("Parsed %d product records from %s", len(records), csvFile)
+	duplicates, err := findDuplicates(stream(csv.NewParser(source, append(parserOpts, csv.WithQuiet())...)), validator, matchKey, onDuplicate)
+	if err != nil {
+		return fmt.Errorf("duplicate check failed: %w", err)
+	}
 
 	db, err := database.NewMongoDB(dbURI, dbName)
 	if err != nil {
@@ -48,68 +235,754 @@ func runImport(cmd *cobra.Command, args []string) error {
 	}
 	defer db.Close()
 
-	insertCount := 0
-	updateCount := 0
-	skippedCount := 0
-	failedCount := 0
-	
-	for i, record := range records {
-		// Validate required fields
-		if record.Number == "" {
-			log.Printf("Skipping record %d: empty Number field (Product: %s)", i+1, record.Product)
-			skippedCount++
-			continue
+	snapshotPath := ""
+	if snapshot && dryRun {
+		log.Printf("Dry run: skipping --snapshot, nothing will be written")
+	} else if snapshot {
+		snapshotPath, err = takeSnapshot(db)
+		if err != nil {
+			return err
 		}
-		
-		if record.Product == "" {
-			log.Printf("Warning: record %d has empty Product field (Number: %s)", i+1, record.Number)
+	}
+
+	importer := newBatchImporter(db, validator, matchKey, dryRun)
+
+	// Every real run is recorded in import_runs, including failed and
+	// rolled back ones. The deferred update reads the named result err, so
+	// nothing in this block may declare an err of its own.
+	if !dryRun {
+		run := newImportRun(source, checksum)
+		run.ResumedAfter = resumeAfter
+		if startErr := db.StartImportRun(run); startErr != nil {
+			return startErr
 		}
+		importer.run = run
 
-		wasUpdate, err := db.UpsertRecord(collection, record)
+		defer func() {
+			finished := time.Now().UTC()
+			run.FinishedAt = &finished
+			run.Counts = importer.stats
+			run.Status = models.RunCompleted
+			if err != nil {
+				run.Status = models.RunFailed
+				run.Error = err.Error()
+			}
+			if finishErr := db.FinishImportRun(run); finishErr != nil {
+				log.Printf("Warning: %v", finishErr)
+			}
+		}()
+	}
+	importer.duplicates = duplicates
+	importer.autoSelect = autoSelect
+	importer.autoSelectOverwrite = autoSelectOverwrite
+	if syncMode {
+		importer.present = make(map[string]bool)
+	}
+
+	// Stored documents may predate normalization, so they are matched on
+	// their normalized Number rather than the raw stored value
+	if number := numberNormalizer(); number.Enabled() {
+		importer.keyIndex, err = db.LoadKeyIndex(collection, matchKey, number.Apply)
 		if err != nil {
-			log.Printf("Failed to upsert record %d (Product: %s, Number: %s): %v", 
-				i+1, record.Product, record.Number, err)
-			failedCount++
-			continue
+			return err
 		}
-		
-		if wasUpdate {
-			updateCount++
+	}
+	importer.headers = parser.Headers
+
+	if rejectsFile != "" {
+		// A resumed import keeps the rows rejected before its checkpoint
+		var rejects *csv.RejectWriter
+		if resumeAfter > 0 {
+			rejects, err = csv.ResumeRejectWriter(rejectsFile, resumeAfter)
 		} else {
-			insertCount++
+			rejects, err = csv.NewRejectWriter(rejectsFile)
 		}
-		
-		totalProcessed := insertCount + updateCount
-		if totalProcessed%100 == 0 {
-			log.Printf("Processed %d records (%d new, %d updated)...", 
-				totalProcessed, insertCount, updateCount)
+		if err != nil {
+			return err
 		}
+		defer func() {
+			if err := rejects.Close(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}()
+		importer.rejects = rejects
 	}
-	
-	if skippedCount > 0 {
-		log.Printf("WARNING: Skipped %d records due to empty fields", skippedCount)
-		log.Printf("Check that your CSV column headers match (case-insensitive):")
-		log.Printf("  Expected: product, number, description, verbal disclaimer")
+
+	if atomic && !dryRun {
+		if err := beginAtomicImport(db); err != nil {
+			return err
+		}
+		importer.atomic = true
+		if err := importer.beginTransaction(); err != nil {
+			return err
+		}
+
+		// Anything not committed when the import stops is rolled back
+		defer func() {
+			if !db.InTransaction() {
+				return
+			}
+			log.Printf("Rolling back uncommitted changes...")
+			if err := db.AbortTransaction(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+			if importer.committed > 0 {
+				log.Printf("WARNING: %d rows were already committed by earlier transactions", importer.committed)
+			}
+		}()
+	}
+
+	importer.resumeAfter = resumeAfter
+	if !dryRun {
+		importer.checkpoint, err = newCheckpoint(source, checksum, settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Records are written batch by batch while the file is still being read
+	if err := stream(parser)(importer.add); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", source, err)
+	}
+	if err := importer.flush(); err != nil {
+		return err
+	}
+
+	// The scan for missing documents reads the whole collection, so it
+	// runs after the imported rows are committed rather than inside their
+	// transaction
+	if syncMode {
+		if importer.atomic {
+			if err := importer.commitTransaction(); err != nil {
+				return err
+			}
+			if err := importer.saveCheckpoint(); err != nil {
+				return err
+			}
+		}
+		if err := importer.syncMissing(missingPolicy); err != nil {
+			return fmt.Errorf("sync failed: %w", err)
+		}
+	}
+
+	if db.InTransaction() {
+		if err := importer.commitTransaction(); err != nil {
+			return err
+		}
+	}
+
+	// A finished import leaves nothing to resume
+	if importer.checkpoint != nil {
+		if err := checkpoint.Remove(checkpointDir, checksum); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	stats := importer.stats
+	log.Printf("Parsed %d product records from %s", stats.Total, source)
+	if importer.resumed > 0 {
+		log.Printf("Skipped %d rows imported before the checkpoint", importer.resumed)
+	}
+
+	if total := importer.errorViolations + importer.warningViolations; total > 0 {
+		log.Printf("\n=== Validation Violations (%d errors, %d warnings) ===",
+			importer.errorViolations, importer.warningViolations)
+		for _, violation := range importer.violations {
+			log.Printf("  %s", violation)
+		}
+		if unlisted := total - len(importer.violations); unlisted > 0 {
+			log.Printf("  ... and %d more, logged as their rows were read", unlisted)
+			if importer.rejects != nil {
+				log.Printf("  Rows skipped by validation are listed in %s", rejectsFile)
+			}
+		}
+	}
+
+	if stats.Skipped > 0 {
+		log.Printf("WARNING: Skipped %d records due to validation errors", stats.Skipped)
+		if mappingFile != "" {
+			log.Printf("Check that your CSV column headers match the aliases in %s", mappingFile)
+		} else {
+			log.Printf("Check that your CSV column headers match (case-insensitive):")
+			log.Printf("  Expected: product, number, description, verbal disclaimer")
+		}
+	}
+
+	if dryRun {
+		log.Printf("\n=== Dry Run Summary (nothing was written) ===")
+		log.Printf("Total records in CSV: %d", stats.Total)
+		log.Printf("Would insert: %d", stats.Inserted)
+		log.Printf("Would update: %d", stats.Updated)
+		log.Printf("Unchanged: %d", stats.Unchanged)
+		log.Printf("Would skip: %d", stats.Skipped)
+		log.Printf("Duplicate rows dropped: %d", stats.Duplicates)
+		if syncMode {
+			log.Printf("Missing from file: %d", stats.Missing)
+			log.Printf("Would archive: %d", stats.Archived)
+			log.Printf("Would delete: %d", stats.Deleted)
+		}
+		log.Printf("Collection: %s.%s", dbName, collection)
+		log.Printf("Match key: %s", strings.Join(matchKey, ", "))
+		if importer.rejects != nil {
+			log.Printf("Rejected rows written to %s: %d", rejectsFile, importer.rejects.Count())
+		}
+		return nil
 	}
 
 	log.Printf("\n=== Import Summary ===")
-	log.Printf("Total records in CSV: %d", len(records))
-	log.Printf("New records inserted: %d", insertCount)
-	log.Printf("Existing records updated: %d", updateCount) 
-	log.Printf("Records skipped: %d", skippedCount)
-	log.Printf("Records failed: %d", failedCount)
+	log.Printf("Total records in CSV: %d", stats.Total)
+	log.Printf("New records inserted: %d", stats.Inserted)
+	log.Printf("Existing records changed: %d", stats.Updated)
+	log.Printf("Existing records unchanged: %d", stats.Unchanged)
+	log.Printf("Records skipped: %d", stats.Skipped)
+	log.Printf("Duplicate rows dropped: %d", stats.Duplicates)
+	log.Printf("Records failed: %d", stats.Failed)
+	if syncMode {
+		log.Printf("Missing from file: %d", stats.Missing)
+		log.Printf("Records archived: %d", stats.Archived)
+		log.Printf("Records deleted: %d", stats.Deleted)
+	}
 	log.Printf("Collection: %s.%s", dbName, collection)
-	
-	if insertCount > 0 || updateCount > 0 {
-		if len(records) > 0 {
-			log.Printf("\nSample record structure:")
-			log.Printf("  Product: %s", records[0].Product)
-			log.Printf("  Number: %s", records[0].Number)
-			log.Printf("  Description: %s", records[0].Description)
-			log.Printf("  DisclaimerVerbiage: %s", records[0].DisclaimerVerbiage)
-			log.Printf("  AutoSelect: %s", records[0].AutoSelect)
-		}
-	}
-	
+	log.Printf("Match key: %s", strings.Join(matchKey, ", "))
+	if snapshotPath != "" {
+		log.Printf("Snapshot: %s", snapshotPath)
+	}
+	log.Printf("Run recorded in %s: %v", database.ImportRunsCollection, importer.run.ID)
+	if importer.rejects != nil {
+		log.Printf("Rejected rows written to %s: %d", rejectsFile, importer.rejects.Count())
+	}
+
+	if (stats.Inserted > 0 || stats.Updated > 0) && importer.sample != nil {
+		sample := importer.sample
+		log.Printf("\nSample record structure:")
+		log.Printf("  Product: %s", sample.Product)
+		log.Printf("  Number: %s", sample.Number)
+		log.Printf("  Description: %s", sample.Description)
+		log.Printf("  DisclaimerVerbiage: %s", sample.DisclaimerVerbiage)
+		log.Printf("  AutoSelect: %s", sample.AutoSelect)
+		for path, value := range sample.Extra {
+			log.Printf("  %s: %s", path, value)
+		}
+	}
+
+	return nil
+}
+
+// beginAtomicImport checks that --atomic can be used. Imports stay within
+// the server's transaction lifetime by committing every --transaction-size
+// rows or transactionMaxAge, so only each chunk is all-or-nothing.
+func beginAtomicImport(db *database.MongoDB) error {
+	supported, err := db.SupportsTransactions()
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return fmt.Errorf("--atomic requires a replica set or sharded cluster")
+	}
+
+	log.Printf("Importing in transactions of up to %d rows or %s; larger imports are committed in chunks, so a failure only rolls back the current chunk",
+		transactionSize, transactionMaxAge)
+	return nil
+}
+
+// maxListedViolations caps the validation violations kept in memory for
+// the import summary
+const maxListedViolations = 1000
+
+// transactionMaxAge bounds how long an --atomic transaction stays open,
+// well below the server's default 60s transactionLifetimeLimitSeconds, as
+// parsing and lookups count towards it as much as writes
+const transactionMaxAge = 30 * time.Second
+
+// parserOptions builds the parser configuration from the command flags and
+// the optional column mapping
+func parserOptions(mapping *csv.Mapping) ([]csv.Option, error) {
+	if err := csv.ValidateEncoding(encoding); err != nil {
+		return nil, err
+	}
+
+	if err := csv.ValidateQuoteMode(quoteMode); err != nil {
+		return nil, err
+	}
+
+	parserOpts := []csv.Option{csv.WithEncoding(encoding), csv.WithQuoteMode(quoteMode)}
+	if delimiter != "" {
+		r, err := csv.ParseSeparator(delimiter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid delimiter: %w", err)
+		}
+		parserOpts = append(parserOpts, csv.WithDelimiter(r))
+	}
+	if comment != "" {
+		r, err := csv.ParseSeparator(comment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid comment character: %w", err)
+		}
+		parserOpts = append(parserOpts, csv.WithComment(r))
+	}
+	if mapping != nil {
+		parserOpts = append(parserOpts, csv.WithMapping(mapping))
+	}
+	if keepExtra || extraField != "" {
+		parserOpts = append(parserOpts, csv.WithExtraColumns(extraField))
+	}
+
+	number := numberNormalizer()
+	if err := number.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Number normalization: %w", err)
+	}
+	if number.Enabled() {
+		parserOpts = append(parserOpts, csv.WithNumberNormalizer(number))
+	}
+
+	return parserOpts, nil
+}
+
+// numberNormalizer builds the Number normalization from the command flags
+func numberNormalizer() normalize.Number {
+	return normalize.Number{
+		ExpandScientific: numberSci,
+		StripChars:       numberStrip,
+		Case:             numberCase,
+		PadWidth:         numberPad,
+	}
+}
+
+// batchImporter collects streamed rows into batches of --batch-size and
+// either writes each batch with a single BulkWrite or, in dry-run mode,
+// reports what the batch would change. key identifies stored documents for
+// lookups, writes, duplicates and --sync.
+type batchImporter struct {
+	db         *database.MongoDB
+	validator  *validation.Validator
+	key        models.MatchKey
+	duplicates *duplicateResolver
+	autoSelect *autoselect.Resolver
+	dryRun     bool
+	// autoSelectOverwrite replaces AutoSelect values already stored
+	autoSelectOverwrite bool
+
+	// atomic writes inside the database transaction, committing every
+	// --transaction-size rows. Any lookup or write error aborts the import.
+	atomic bool
+	// run is the audit record of this import; nil in dry-run mode, which
+	// records nothing
+	run *models.ImportRun
+	// pending counts the rows written in the open transaction, started at
+	// transactionStarted, and committed the rows covered by earlier
+	// transactions
+	pending            int
+	committed          int
+	transactionStarted time.Time
+
+	// checkpoint tracks the last row read, lastRow, once its outcome is
+	// committed; nil in dry-run mode. Rows up to resumeAfter were imported
+	// by an earlier run and are only counted in resumed.
+	checkpoint  *checkpoint.Checkpoint
+	lastRow     int
+	resumeAfter int
+	resumed     int
+
+	// keyIndex resolves normalized keys to stored documents when Number
+	// normalization is enabled
+	keyIndex *database.KeyIndex
+
+	// planner spans the whole file in dry-run mode, so a key repeated in
+	// the CSV is compared against its earlier row rather than the stored
+	// document. A real import writes each batch before looking up the next,
+	// so it plans batch by batch.
+	planner *changePlanner
+
+	// rejects receives skipped and failed rows when --rejects is set, using
+	// the header row returned by headers
+	rejects *csv.RejectWriter
+	headers func() []string
+
+	// present collects the match key of every row when --sync is set,
+	// including rows that are skipped or fail
+	present map[string]bool
+
+	// violations keeps the first maxListedViolations violations for the
+	// summary; later ones are logged as they are found and only counted
+	violations        []validation.Violation
+	errorViolations   int
+	warningViolations int
+
+	batch  []csv.Row
+	stats  models.ImportCounts
+	sample *models.ProductRecord
+}
+
+func newBatchImporter(db *database.MongoDB, validator *validation.Validator, key models.MatchKey, dryRun bool) *batchImporter {
+	b := &batchImporter{
+		db:        db,
+		validator: validator,
+		key:       key,
+		dryRun:    dryRun,
+		batch:     make([]csv.Row, 0, batchSize),
+	}
+	return b
+}
+
+// add queues a parsed row and flushes the batch once it is full
+func (b *batchImporter) add(row csv.Row) error {
+	record := row.Record
+	if b.present != nil {
+		b.present[b.key.Value(record)] = true
+	}
+	if row.RowNumber <= b.resumeAfter {
+		b.resumed++
+		return nil
+	}
+	b.stats.Total++
+	b.lastRow = row.RowNumber
+
+	// Rows with error-severity violations are skipped, warnings are only
+	// reported in the summary
+	violations := b.validator.Validate(row.RowNumber, record)
+	for _, violation := range violations {
+		if violation.Severity == validation.SeverityError {
+			b.errorViolations++
+		} else {
+			b.warningViolations++
+		}
+		if len(b.violations) < maxListedViolations {
+			b.violations = append(b.violations, violation)
+		} else {
+			log.Printf("Validation: %s", violation)
+		}
+	}
+	if validation.HasErrors(violations) {
+		log.Printf("Skipping row %d: failed validation (Product: %s, Number: %s)",
+			row.RowNumber, record.Product, record.Number)
+		b.stats.Skipped++
+		if err := b.reject(row, csv.RejectSkipped, firstError(violations)); err != nil {
+			return err
+		}
+		return b.checkFailures()
+	}
+
+	if b.duplicates != nil {
+		resolved, winner, keep := b.duplicates.resolve(row)
+		if !keep {
+			log.Printf("Dropping row %d: %s is imported from row %d (--on-duplicate %s)",
+				row.RowNumber, b.key.Describe(record), winner, b.duplicates.policy)
+			b.stats.Duplicates++
+			return b.reject(row, csv.RejectDuplicate, fmt.Sprintf("duplicate of row %d", winner))
+		}
+		row.Record = resolved
+	}
+	if b.autoSelect != nil {
+		b.autoSelect.Apply(&row.Record)
+	}
+	if b.sample == nil {
+		sample := row.Record
+		b.sample = &sample
+	}
+
+	b.batch = append(b.batch, row)
+	if len(b.batch) >= batchSize {
+		return b.flush()
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// flush plans and writes the queued rows
+func (b *batchImporter) flush() error {
+	if len(b.batch) == 0 {
+		return nil
+	}
+	defer func() {
+		b.batch = b.batch[:0]
+	}()
+
+	first, last := b.batch[0].RowNumber, b.batch[len(b.batch)-1].RowNumber
+
+	planner := b.planner
+	if planner == nil {
+		planner = newChangePlanner(b.key, b.autoSelectOverwrite)
+		if b.dryRun {
+			b.planner = planner
+		}
+	}
+
+	records := make([]models.ProductRecord, len(b.batch))
+	for i, row := range b.batch {
+		records[i] = row.Record
+	}
+
+	existing, err := b.db.FindRecords(collection, b.key, planner.unknownRecords(records), b.keyIndex)
+	if err != nil {
+		if b.dryRun || b.atomic {
+			return fmt.Errorf("failed to look up existing records: %w", err)
+		}
+		log.Printf("Failed to look up batch of %d records (rows %d-%d): %v", len(b.batch), first, last, err)
+		b.stats.Failed += len(b.batch)
+		return b.rejectAll(b.batch, err)
+	}
+	planner.addExisting(existing)
+
+	// Only records that would actually change anything are written;
+	// befores keeps the stored state of each write for the change log
+	var writes []csv.Row
+	var befores []*models.ProductRecord
+	for _, row := range b.batch {
+		before := planner.current(row.Record)
+		change, fields := planner.plan(&row.Record)
+		if b.dryRun {
+			b.reportPlanned(row, change, fields)
+			continue
+		}
+		if change == models.ChangeUnchanged {
+			b.stats.Unchanged++
+			continue
+		}
+		writes = append(writes, row)
+		befores = append(befores, before)
+	}
+
+	if b.dryRun {
+		return nil
+	}
+	if len(writes) == 0 {
+		return b.commitChunk()
+	}
+
+	writeRecords := make([]models.ProductRecord, len(writes))
+	for i, row := range writes {
+		writeRecords[i] = row.Record
+	}
+
+	result, err := b.db.BulkUpsertRecords(collection, b.key, writeRecords, ordered)
+	if b.atomic {
+		// A failed write aborts the transaction on the server, so the
+		// import stops and runImport rolls back
+		if err != nil {
+			return fmt.Errorf("failed to write batch (rows %d-%d): %w", first, last, err)
+		}
+		for idx, row := range writes {
+			if writeErr, failed := result.Errors[idx]; failed {
+				return fmt.Errorf("failed to upsert row %d (%s): %w", row.RowNumber, b.key.Describe(row.Record), writeErr)
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to write batch of %d records (rows %d-%d): %v", len(writes), first, last, err)
+		b.stats.Failed += len(writes)
+		if err := b.rejectAll(writes, err); err != nil {
+			return err
+		}
+	} else {
+		var history []models.RecordHistory
+		for idx, row := range writes {
+			writeErr, failed := result.Errors[idx]
+			if !failed {
+				record := row.Record
+				if befores[idx] == nil {
+					history = append(history, b.historyEntry(result.UpsertedIDs[idx], models.ChangeInsert, nil, &record))
+				} else {
+					history = append(history, b.historyEntry(record.ID, models.ChangeUpdate, befores[idx], &record))
+				}
+				continue
+			}
+			log.Printf("Failed to upsert row %d (Product: %s, Number: %s): %v",
+				row.RowNumber, row.Record.Product, row.Record.Number, writeErr)
+			if err := b.reject(row, csv.RejectFailed, writeErr.Error()); err != nil {
+				return err
+			}
+		}
+		b.stats.Inserted += result.Inserted
+		b.stats.Updated += result.Updated
+		b.stats.Failed += result.Failed
+
+		if err := b.recordHistory(history); err != nil {
+			return err
+		}
+	}
+
+	stats := b.stats
+	log.Printf("Processed %d records (%d new, %d changed, %d unchanged, %d failed)...",
+		stats.Inserted+stats.Updated+stats.Unchanged+stats.Failed,
+		stats.Inserted, stats.Updated, stats.Unchanged, stats.Failed)
+
+	if err := b.checkFailures(); err != nil {
+		return err
+	}
+	return b.commitChunk()
+}
+
+// checkFailures stops the import once --max-failures rows were skipped or
+// failed
+func (b *batchImporter) checkFailures() error {
+	failures := b.stats.Skipped + b.stats.Failed
+	if maxFailures > 0 && failures >= maxFailures {
+		kept := "rows written by earlier batches are kept"
+		if b.atomic {
+			kept = "only the open transaction is rolled back, earlier committed chunks are kept"
+		}
+		return fmt.Errorf("aborting import: %d rows skipped or failed (--max-failures %d); %s", failures, maxFailures, kept)
+	}
+	return nil
+}
+
+// commitChunk makes a flushed batch durable. Without --atomic the batch is
+// already written and only the checkpoint advances; with it, the open
+// transaction is committed and the next one started once it covers
+// --transaction-size rows or has been open for transactionMaxAge. The final
+// chunk is committed by runImport.
+func (b *batchImporter) commitChunk() error {
+	if !b.atomic {
+		return b.saveCheckpoint()
+	}
+	b.pending += len(b.batch)
+	if b.pending < transactionSize && time.Since(b.transactionStarted) < transactionMaxAge {
+		return nil
+	}
+
+	if err := b.commitTransaction(); err != nil {
+		return err
+	}
+	if err := b.saveCheckpoint(); err != nil {
+		return err
+	}
+	return b.beginTransaction()
+}
+
+// beginTransaction opens the next --atomic transaction
+func (b *batchImporter) beginTransaction() error {
+	if err := b.db.BeginTransaction(); err != nil {
+		return err
+	}
+	b.transactionStarted = time.Now()
+	return nil
+}
+
+// commitTransaction commits the open --atomic transaction and counts its
+// rows as committed
+func (b *batchImporter) commitTransaction() error {
+	if err := b.db.CommitTransaction(); err != nil {
+		return err
+	}
+	b.committed += b.pending
+	b.pending = 0
+	log.Printf("Committed transaction (%d rows committed so far)", b.committed)
+	return nil
+}
+
+// reject writes a row to the rejects file when one is configured
+func (b *batchImporter) reject(row csv.Row, reason, message string) error {
+	if b.rejects == nil {
+		return nil
+	}
+	return b.rejects.Write(b.headers(), row, reason, message)
+}
+
+func (b *batchImporter) rejectAll(rows []csv.Row, err error) error {
+	for _, row := range rows {
+		if rejectErr := b.reject(row, csv.RejectFailed, err.Error()); rejectErr != nil {
+			return rejectErr
+		}
+	}
+	return nil
+}
+
+// firstError describes the first error-severity violation
+func firstError(violations []validation.Violation) string {
+	for _, violation := range violations {
+		if violation.Severity == validation.SeverityError {
+			return fmt.Sprintf("%s %s: %s", violation.Field, violation.Check, violation.Message)
+		}
+	}
+	return ""
+}
+
+// reportPlanned logs the change a dry run found for a single row
+func (b *batchImporter) reportPlanned(row csv.Row, change models.ChangeType, fields []models.FieldChange) {
+	record := row.Record
+	switch change {
+	case models.ChangeInsert:
+		log.Printf("Row %d: insert %s (Product: %s)", row.RowNumber, b.key.Describe(record), record.Product)
+		b.stats.Inserted++
+	case models.ChangeUpdate:
+		log.Printf("Row %d: update %s", row.RowNumber, b.key.Describe(record))
+		for _, field := range fields {
+			log.Printf("    %s: %q -> %q", field.Field, field.Old, field.New)
+		}
+		b.stats.Updated++
+	default:
+		log.Printf("Row %d: unchanged %s", row.RowNumber, b.key.Describe(record))
+		b.stats.Unchanged++
+	}
+}
+
+// changePlanner labels records as insert, update or unchanged by comparing
+// them with the stored documents and with earlier rows for the same key
+type changePlanner struct {
+	key models.MatchKey
+	// overwriteAutoSelect replaces stored AutoSelect values instead of
+	// keeping them
+	overwriteAutoSelect bool
+	known               map[string]models.ProductRecord
+}
+
+func newChangePlanner(key models.MatchKey, overwriteAutoSelect bool) *changePlanner {
+	return &changePlanner{key: key, overwriteAutoSelect: overwriteAutoSelect, known: make(map[string]models.ProductRecord)}
+}
+
+// unknownRecords returns one record per distinct key in records that still
+// has to be looked up in the collection
+func (p *changePlanner) unknownRecords(records []models.ProductRecord) []models.ProductRecord {
+	var unknown []models.ProductRecord
+	seen := make(map[string]bool)
+	for _, record := range records {
+		value := p.key.Value(record)
+		if seen[value] {
+			continue
+		}
+		if _, ok := p.known[value]; !ok {
+			unknown = append(unknown, record)
+		}
+		seen[value] = true
+	}
+	return unknown
+}
+
+// addExisting records the stored documents returned by a lookup
+func (p *changePlanner) addExisting(existing map[string]models.ProductRecord) {
+	for value, record := range existing {
+		if _, ok := p.known[value]; !ok {
+			p.known[value] = record
+		}
+	}
+}
+
+// current returns the stored state known for the key of record, or nil
+func (p *changePlanner) current(record models.ProductRecord) *models.ProductRecord {
+	if current, exists := p.known[p.key.Value(record)]; exists {
+		return &current
+	}
+	return nil
+}
+
+// plan labels record, links it to the stored document it matches and
+// remembers it as the new state for its key. A stored AutoSelect is kept
+// unless overwriteAutoSelect is set.
+func (p *changePlanner) plan(record *models.ProductRecord) (models.ChangeType, []models.FieldChange) {
+	value := p.key.Value(*record)
+	current, exists := p.known[value]
+	if exists {
+		record.ID = current.ID
+		if current.AutoSelect != "" && !p.overwriteAutoSelect {
+			record.AutoSelect = ""
+		}
+	}
+	p.known[value] = *record
+
+	if !exists {
+		return models.ChangeInsert, nil
+	}
+	if changes := record.Diff(current); len(changes) > 0 {
+		return models.ChangeUpdate, changes
+	}
+	return models.ChangeUnchanged, nil
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"excelDisclaimer/internal/csv"
+	"excelDisclaimer/internal/models"
+	"excelDisclaimer/internal/validation"
+)
+
+// Policies for --on-duplicate
+const (
+	duplicateFirst = "first"
+	duplicateLast  = "last"
+	duplicateError = "error"
+	duplicateMerge = "merge"
+)
+
+// rowStreamer runs one parsing pass over the input file
+type rowStreamer func(fn func(csv.Row) error) error
+
+// duplicateResolver knows which rows of the input share a match key and
+// decides which of them are imported under the --on-duplicate policy
+type duplicateResolver struct {
+	policy string
+	key    models.MatchKey
+	// rows lists the row numbers of every key seen more than once
+	rows map[string][]int
+	// merged holds the combined record for each duplicated key when the
+	// policy is merge
+	merged map[string]models.ProductRecord
+}
+
+func validateDuplicatePolicy(policy string) error {
+	switch policy {
+	case duplicateFirst, duplicateLast, duplicateError, duplicateMerge:
+		return nil
+	}
+	return fmt.Errorf("invalid duplicate policy: %s. Use 'first', 'last', 'error' or 'merge'", policy)
+}
+
+// findDuplicates scans the input before anything is written and reports
+// every match key that appears on more than one valid row, whatever the
+// policy, so a key repeated far apart in the file is still written once.
+// It keeps every distinct key of the file in memory with the row it first
+// appears on. The records of duplicated rows are loaded in a second pass,
+// which is skipped when there are no duplicates.
+func findDuplicates(stream rowStreamer, validator *validation.Validator, key models.MatchKey, policy string) (*duplicateResolver, error) {
+	resolver := &duplicateResolver{policy: policy, key: key, rows: make(map[string][]int)}
+
+	firstRow := make(map[string]int)
+	err := stream(func(row csv.Row) error {
+		if validation.HasErrors(validator.Validate(row.RowNumber, row.Record)) {
+			return nil
+		}
+
+		value := key.Value(row.Record)
+		first, seen := firstRow[value]
+		if !seen {
+			firstRow[value] = row.RowNumber
+			return nil
+		}
+		if resolver.rows[value] == nil {
+			resolver.rows[value] = []int{first}
+		}
+		resolver.rows[value] = append(resolver.rows[value], row.RowNumber)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resolver.rows) == 0 {
+		return resolver, nil
+	}
+
+	duplicateRows := make(map[int]bool)
+	for _, rows := range resolver.rows {
+		for _, row := range rows {
+			duplicateRows[row] = true
+		}
+	}
+
+	records := make(map[int]models.ProductRecord, len(duplicateRows))
+	err = stream(func(row csv.Row) error {
+		if duplicateRows[row.RowNumber] {
+			records[row.RowNumber] = row.Record
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resolver.report(records)
+
+	if policy == duplicateError {
+		return nil, fmt.Errorf("%d keys appear on more than one row (use --on-duplicate to choose a resolution)", len(resolver.rows))
+	}
+
+	if policy == duplicateMerge {
+		resolver.merged = make(map[string]models.ProductRecord, len(resolver.rows))
+		for value, rows := range resolver.rows {
+			merged := records[rows[0]]
+			for _, row := range rows[1:] {
+				merged = mergeRecords(merged, records[row])
+			}
+			resolver.merged[value] = merged
+		}
+	}
+
+	return resolver, nil
+}
+
+// report logs every duplicated key with the values found on each row,
+// ordered by the row the key first appears on
+func (d *duplicateResolver) report(records map[int]models.ProductRecord) {
+	values := make([]string, 0, len(d.rows))
+	for value := range d.rows {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return d.rows[values[i]][0] < d.rows[values[j]][0]
+	})
+
+	log.Printf("\n=== Duplicate Keys (%d, policy: %s) ===", len(values), d.policy)
+	for _, value := range values {
+		rows := d.rows[value]
+		rowList := make([]string, len(rows))
+		for i, row := range rows {
+			rowList[i] = fmt.Sprint(row)
+		}
+		log.Printf("%s appears on rows %s", d.key.Describe(records[rows[0]]), strings.Join(rowList, ", "))
+
+		for _, row := range rows {
+			record := records[row]
+			log.Printf("  row %d: Product=%q Description=%q DisclaimerVerbiage=%q",
+				row, record.Product, record.Description, record.DisclaimerVerbiage)
+		}
+	}
+}
+
+// resolve returns the record to import for row, or false and the row that
+// wins instead when the row is dropped by the policy
+func (d *duplicateResolver) resolve(row csv.Row) (models.ProductRecord, int, bool) {
+	value := d.key.Value(row.Record)
+	rows, duplicated := d.rows[value]
+	if !duplicated {
+		return row.Record, 0, true
+	}
+
+	// first keeps the first row; last and merge write on the last row so
+	// the import order matches a plain last-row-wins import
+	keep := rows[len(rows)-1]
+	if d.policy == duplicateFirst {
+		keep = rows[0]
+	}
+	if row.RowNumber != keep {
+		return models.ProductRecord{}, keep, false
+	}
+
+	if d.policy == duplicateMerge {
+		return d.merged[value], 0, true
+	}
+	return row.Record, 0, true
+}
+
+// mergeRecords overlays the non-empty values of next onto base
+func mergeRecords(base, next models.ProductRecord) models.ProductRecord {
+	for _, field := range models.MappableFields {
+		if value, _ := next.Field(field); value != "" {
+			base.SetField(field, value)
+		}
+	}
+
+	if len(next.Extra) > 0 {
+		extra := make(map[string]string, len(base.Extra)+len(next.Extra))
+		for path, value := range base.Extra {
+			extra[path] = value
+		}
+		for path, value := range next.Extra {
+			if _, exists := extra[path]; !exists || value != "" {
+				extra[path] = value
+			}
+		}
+		base.Extra = extra
+	}
+	return base
+}
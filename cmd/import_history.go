@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"excelDisclaimer/internal/models"
+)
+
+// newImportRun describes the run about to import source
+func newImportRun(source, checksum string) *models.ImportRun {
+	path, err := filepath.Abs(source)
+	if err != nil {
+		path = source
+	}
+
+	return &models.ImportRun{
+		File:       path,
+		SHA256:     checksum,
+		Database:   dbName,
+		Collection: collection,
+		User:       currentUser(),
+		StartedAt:  time.Now().UTC(),
+		Status:     models.RunRunning,
+	}
+}
+
+func fileSHA256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to checksum %s: %w", filename, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// currentUser returns the OS account running the import
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// historyEntry describes a change to a single document. before is nil for
+// inserts and after for deletes.
+func (b *batchImporter) historyEntry(id interface{}, change models.ChangeType, before, after *models.ProductRecord) models.RecordHistory {
+	entry := models.RecordHistory{
+		RunID:      b.run.ID,
+		DocumentID: id,
+		Collection: collection,
+		Change:     change,
+		User:       b.run.User,
+		ChangedAt:  time.Now().UTC(),
+	}
+	if before != nil {
+		entry.Before = before.Core()
+		entry.Number = before.Number
+	}
+	if after != nil {
+		entry.After = after.Core()
+		entry.Number = after.Number
+	}
+	return entry
+}
+
+// recordHistory writes change log entries when the run is being recorded
+func (b *batchImporter) recordHistory(entries []models.RecordHistory) error {
+	if b.run == nil {
+		return nil
+	}
+	return b.db.InsertRecordHistory(entries)
+}
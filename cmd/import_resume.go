@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"excelDisclaimer/internal/checkpoint"
+	"excelDisclaimer/internal/models"
+)
+
+// resumePoint returns the last row recorded by the checkpoint of source,
+// refusing to resume when the file changed since the checkpoint was
+// written, when it was taken for another collection or when the file is
+// read with other settings
+func resumePoint(source, checksum string, settings checkpoint.Settings) (int, error) {
+	path, err := filepath.Abs(source)
+	if err != nil {
+		path = source
+	}
+
+	cp, err := checkpoint.Load(checkpointDir, checksum)
+	if err != nil {
+		return 0, err
+	}
+	if cp == nil {
+		stale, err := checkpoint.FindByFile(checkpointDir, path)
+		if err != nil {
+			return 0, err
+		}
+		if stale != nil {
+			return 0, fmt.Errorf("refusing to resume: %s has changed since its checkpoint was written (checksum %s, now %s)",
+				source, stale.SHA256, checksum)
+		}
+		return 0, fmt.Errorf("no checkpoint found for %s in %s", source, checkpointDir)
+	}
+
+	if cp.Database != dbName || cp.Collection != collection {
+		return 0, fmt.Errorf("refusing to resume: the checkpoint for %s was written for %s.%s, not %s.%s",
+			source, cp.Database, cp.Collection, dbName, collection)
+	}
+	if changed := cp.Settings.Diff(settings); len(changed) > 0 {
+		return 0, fmt.Errorf("refusing to resume: %s is imported with different settings than when its checkpoint was written (%s); rerun with the same settings or without --resume",
+			source, strings.Join(changed, ", "))
+	}
+
+	log.Printf("Resuming import of %s after row %d (checkpoint from %s)",
+		source, cp.LastRow, cp.UpdatedAt.Local().Format("2006-01-02 15:04:05"))
+	return cp.LastRow, nil
+}
+
+// newCheckpoint starts tracking progress through source, warning when an
+// earlier checkpoint is about to be replaced
+func newCheckpoint(source, checksum string, settings checkpoint.Settings) (*checkpoint.Checkpoint, error) {
+	path, err := filepath.Abs(source)
+	if err != nil {
+		path = source
+	}
+
+	if !resume {
+		existing, err := checkpoint.Load(checkpointDir, checksum)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			log.Printf("WARNING: starting over although a checkpoint for this file stops at row %d; use --resume to continue from it",
+				existing.LastRow)
+		}
+	}
+
+	return &checkpoint.Checkpoint{
+		File:       path,
+		SHA256:     checksum,
+		Database:   dbName,
+		Collection: collection,
+		Settings:   settings,
+	}, nil
+}
+
+// checkpointSettings collects the options a resumed import has to share
+// with the run that wrote the checkpoint
+func checkpointSettings(key models.MatchKey) (checkpoint.Settings, error) {
+	settings := checkpoint.Settings{
+		Key:       strings.Join(key, ","),
+		Encoding:  encoding,
+		Delimiter: delimiter,
+		Comment:   comment,
+		QuoteMode: quoteMode,
+
+		NumberExpandSci: numberSci,
+		NumberStrip:     numberStrip,
+		NumberCase:      numberCase,
+		NumberPad:       numberPad,
+		OnDuplicate:     onDuplicate,
+	}
+	if xlsxFile != "" {
+		settings.Sheet = sheetName
+	}
+	if mappingFile != "" {
+		sum, err := fileSHA256(mappingFile)
+		if err != nil {
+			return settings, err
+		}
+		settings.Mapping = sum
+	}
+	return settings, nil
+}
+
+// saveCheckpoint records every row read so far as committed. Once a row
+// has failed the checkpoint stops advancing, so resuming after a lost
+// connection retries everything from the first failure on.
+func (b *batchImporter) saveCheckpoint() error {
+	if b.checkpoint == nil || b.stats.Failed > 0 {
+		return nil
+	}
+	b.checkpoint.LastRow = b.lastRow
+	return checkpoint.Save(checkpointDir, b.checkpoint)
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"excelDisclaimer/internal/backup"
+	"excelDisclaimer/internal/database"
+)
+
+// takeSnapshot backs up the target collection before the import writes
+// anything and prints the restore command that undoes the import
+func takeSnapshot(db *database.MongoDB) (string, error) {
+	log.Printf("Taking snapshot of collection '%s' in %s...", collection, snapshotDir)
+	path, err := backup.NewService(db).BackupCollection(collection, snapshotDir, backup.Options{Format: "bson"})
+	if err != nil {
+		return "", fmt.Errorf("snapshot failed: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("snapshot failed: %w", err)
+	}
+	if info.Size() == 0 {
+		log.Printf("Snapshot saved to %s; the collection was empty, so the import is undone by dropping '%s.%s'",
+			path, dbName, collection)
+		return path, nil
+	}
+
+	command := restoreCommand(path)
+	log.Printf("Snapshot saved to %s. To undo this import, run:", path)
+	if strings.Contains(command, "$DB_URI") {
+		log.Printf("  (with DB_URI set to the connection string used for this import)")
+	}
+	log.Printf("  %s", command)
+	return path, nil
+}
+
+// restoreCommand builds the restore invocation that puts the snapshot back
+// in place of the imported collection
+func restoreCommand(path string) string {
+	args := []string{os.Args[0], "restore",
+		"--input", path,
+		"--database", dbName,
+		"--collection", collection,
+		"--drop",
+	}
+	for i, arg := range args {
+		args[i] = shellQuote(arg)
+	}
+
+	// A DB_URI environment variable overrides the flag on restore as well.
+	// Credentials in the URI are kept out of the log by referring to the
+	// variable instead.
+	if os.Getenv("DB_URI") == "" && dbURI != "mongodb://localhost:27017" {
+		if hasCredentials(dbURI) {
+			args = append(args, "--db-uri", `"$DB_URI"`)
+		} else {
+			args = append(args, "--db-uri", shellQuote(dbURI))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// hasCredentials reports whether a connection URI embeds a user name or
+// password. URIs that cannot be parsed are treated as secret.
+func hasCredentials(uri string) bool {
+	parsed, err := url.Parse(uri)
+	return err != nil || parsed.User != nil
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes arg for a POSIX shell when it contains anything other
+// than plain path characters
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"excelDisclaimer/internal/models"
+)
+
+// Policies for --missing
+const (
+	missingArchive = "archive"
+	missingDelete  = "delete"
+	missingReport  = "report"
+)
+
+func validateMissingPolicy(policy string) error {
+	switch policy {
+	case missingArchive, missingDelete, missingReport:
+		return nil
+	}
+	return fmt.Errorf("invalid missing policy: %s. Use 'archive', 'delete' or 'report'", policy)
+}
+
+// syncMissing handles the stored documents whose match key does not appear
+// anywhere in the imported file. Rows that were skipped or failed still
+// count as present, so a bad row never archives or deletes its document.
+func (b *batchImporter) syncMissing(policy string) error {
+	db, stats := b.db, &b.stats
+	if len(b.present) == 0 {
+		return fmt.Errorf("refusing to sync: the file contains no records")
+	}
+
+	var normalize func(string) string
+	if number := numberNormalizer(); number.Enabled() {
+		normalize = number.Apply
+	}
+
+	missing, err := db.FindMissingRecords(collection, b.key, b.present, normalize)
+	if err != nil {
+		return err
+	}
+	stats.Missing = len(missing)
+
+	log.Printf("\n=== Missing From File (%d, policy: %s) ===", len(missing), policy)
+	ids := make([]interface{}, 0, len(missing))
+	var changed []*models.ProductRecord
+	for i, record := range missing {
+		state := ""
+		if record.Archived {
+			state = " [archived]"
+		}
+		log.Printf("  %s (Product: %s)%s", b.key.Describe(record), record.Product, state)
+
+		// Archiving leaves documents that are already archived alone so
+		// their ArchivedAt keeps the time they first went missing
+		if policy == missingArchive && record.Archived {
+			continue
+		}
+		ids = append(ids, record.ID)
+		changed = append(changed, &missing[i])
+	}
+
+	if policy == missingReport || len(ids) == 0 {
+		return nil
+	}
+
+	// A dry run counts what would be archived or deleted
+	if dryRun {
+		if policy == missingArchive {
+			stats.Archived = len(ids)
+		} else {
+			stats.Deleted = len(ids)
+		}
+		return nil
+	}
+
+	// History is only built once something is written; a dry run has no
+	// import run to attach it to
+	history := make([]models.RecordHistory, 0, len(changed))
+	for _, record := range changed {
+		if policy == missingArchive {
+			history = append(history, b.historyEntry(record.ID, models.ChangeArchive, record, record))
+		} else {
+			history = append(history, b.historyEntry(record.ID, models.ChangeDelete, record, nil))
+		}
+	}
+
+	// The writes and their history are one transaction with --atomic; the
+	// scan above ran outside it
+	if b.atomic {
+		if err := b.beginTransaction(); err != nil {
+			return err
+		}
+	}
+
+	switch policy {
+	case missingArchive:
+		stats.Archived, err = db.ArchiveRecords(collection, ids, time.Now().UTC())
+	case missingDelete:
+		stats.Deleted, err = db.DeleteRecords(collection, ids)
+	}
+	if err != nil {
+		return err
+	}
+	return b.recordHistory(history)
+}
@@ -20,47 +20,73 @@ var (
 	restoreCollection string
 	dropExisting     bool
 	skipConfirmation bool
+	manifestPath     string
+	restoreOnly      string
+	noIndexes        bool
 )
 
 var restoreCmd = &cobra.Command{
 	Use:   "restore",
 	Short: "Restore MongoDB collections from backup",
-	Long:  "Restore MongoDB collections from BSON or JSON backup files",
+	Long:  "Restore MongoDB collections from BSON or JSON backup files, optionally gzip or zstd compressed, or from a directory or archive created by mongodump",
 	RunE:  runRestore,
 }
 
 func init() {
-	restoreCmd.Flags().StringVarP(&inputFile, "input", "i", "", "Input backup file to restore (required)")
+	restoreCmd.Flags().StringVarP(&inputFile, "input", "i", "", "Input backup file, mongodump directory or mongodump archive to restore")
+	restoreCmd.Flags().StringVar(&manifestPath, "manifest", "", "Restore a backup set from its manifest.json or directory")
+	restoreCmd.Flags().StringVar(&restoreOnly, "collections", "", "With --manifest, a mongodump directory or archive, comma-separated collections to restore (defaults to all)")
 	restoreCmd.Flags().StringVarP(&restoreFormat, "format", "f", "", "Backup format: bson or json (auto-detected if not specified)")
 	restoreCmd.Flags().StringVarP(&restoreCollection, "collection", "c", "", "Target collection name (defaults to original collection name from backup)")
 	restoreCmd.Flags().BoolVar(&dropExisting, "drop", false, "Drop existing collection before restore")
+	restoreCmd.Flags().BoolVar(&noIndexes, "no-indexes", false, "Do not recreate the indexes saved with the backup")
 	restoreCmd.Flags().BoolVar(&skipConfirmation, "yes", false, "Skip confirmation prompts")
 	restoreCmd.Flags().StringVarP(&dbURI, "db-uri", "u", "mongodb://localhost:27017", "MongoDB connection URI")
 	restoreCmd.Flags().StringVarP(&dbName, "database", "d", "csvprocessor", "Database name")
 	
-	restoreCmd.MarkFlagRequired("input")
+	restoreCmd.MarkFlagsOneRequired("input", "manifest")
+	restoreCmd.MarkFlagsMutuallyExclusive("input", "manifest")
+	restoreCmd.MarkFlagsMutuallyExclusive("collection", "manifest")
 }
 
 func runRestore(cmd *cobra.Command, args []string) error {
+	if manifestPath != "" {
+		return runManifestRestore()
+	}
+
 	if inputFile == "" {
 		return fmt.Errorf("input file is required")
 	}
 
-	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
+	info, err := os.Stat(inputFile)
+	if os.IsNotExist(err) {
 		return fmt.Errorf("backup file does not exist: %s", inputFile)
 	}
 
+	// Directories are backup sets or mongodump output; a single file may be
+	// a mongodump archive
+	if err == nil && info.IsDir() {
+		return runDumpRestore()
+	}
+	archive, err := backup.IsArchive(inputFile)
+	if err != nil {
+		return err
+	}
+	if archive {
+		return runArchiveRestore()
+	}
+
+	if restoreOnly != "" {
+		return fmt.Errorf("--collections requires --manifest, a mongodump directory or an archive")
+	}
+
 	format := restoreFormat
 	if format == "" {
-		extension := filepath.Ext(inputFile)
-		switch extension {
-		case ".bson":
-			format = "bson"
-		case ".json":
-			format = "json"
-		default:
-			return fmt.Errorf("cannot auto-detect format from extension '%s'. Please specify --format", extension)
+		detected, err := backup.DetectFormat(inputFile)
+		if err != nil {
+			return fmt.Errorf("%w. Please specify --format", err)
 		}
+		format = detected
 	}
 
 	if format != "bson" && format != "json" {
@@ -111,7 +137,7 @@ func runRestore(cmd *cobra.Command, args []string) error {
 
 	log.Printf("Starting restore of collection '%s' from %s...", targetCollection, inputFile)
 	
-	if err := backupService.RestoreCollection(targetCollection, inputFile, format, dropExisting); err != nil {
+	if err := backupService.RestoreCollection(targetCollection, inputFile, format, dropExisting, !noIndexes); err != nil {
 		return fmt.Errorf("restore failed: %w", err)
 	}
 
@@ -119,6 +145,146 @@ func runRestore(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// runManifestRestore restores a whole backup set, or the collections
+// selected with --collections, into the target database
+func runManifestRestore() error {
+	manifest, _, err := backup.LoadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	entries, err := manifest.Select(splitList(restoreOnly))
+	if err != nil {
+		return err
+	}
+
+	if !skipConfirmation {
+		log.Printf("About to restore:")
+		log.Printf("  Backup set: %s (database '%s', %s)", manifestPath, manifest.Database, manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		log.Printf("  Target database: %s", dbName)
+		for _, entry := range entries {
+			log.Printf("  - %s: %d documents (%s, %s)", entry.Collection, entry.Documents, entry.Format, entry.Compression)
+		}
+		if dropExisting {
+			log.Printf("  WARNING: Existing collections will be DROPPED!")
+		}
+
+		if !confirmAction("Do you want to continue?") {
+			log.Println("Restore cancelled")
+			return nil
+		}
+	}
+
+	db, err := database.NewMongoDB(dbURI, dbName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer db.Close()
+
+	if err := backup.NewService(db).RestoreManifest(manifestPath, splitList(restoreOnly), dropExisting, !noIndexes); err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	log.Printf("Restore completed successfully! Restored %d collections into '%s'", len(entries), dbName)
+	return nil
+}
+
+// runDumpRestore restores a directory given with --input: a backup set when
+// it holds a manifest.json, otherwise mongodump output
+func runDumpRestore() error {
+	if restoreCollection != "" {
+		return fmt.Errorf("--collection cannot be used with a directory, use --collections")
+	}
+	if _, err := os.Stat(filepath.Join(inputFile, backup.ManifestFile)); err == nil {
+		manifestPath = inputFile
+		return runManifestRestore()
+	}
+
+	manifest, _, err := backup.LoadDump(inputFile)
+	if err != nil {
+		return err
+	}
+	entries, err := manifest.Select(splitList(restoreOnly))
+	if err != nil {
+		return err
+	}
+
+	if !skipConfirmation {
+		log.Printf("About to restore:")
+		log.Printf("  mongodump directory: %s (database '%s')", inputFile, manifest.Database)
+		log.Printf("  Target database: %s", dbName)
+		for _, entry := range entries {
+			log.Printf("  - %s: %d bytes (%s)", entry.Collection, entry.Bytes, entry.Compression)
+		}
+		if dropExisting {
+			log.Printf("  WARNING: Existing collections will be DROPPED!")
+		}
+
+		if !confirmAction("Do you want to continue?") {
+			log.Println("Restore cancelled")
+			return nil
+		}
+	}
+
+	db, err := database.NewMongoDB(dbURI, dbName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer db.Close()
+
+	if err := backup.NewService(db).RestoreDump(inputFile, splitList(restoreOnly), dropExisting, !noIndexes); err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	log.Printf("Restore completed successfully! Restored %d collections into '%s'", len(entries), dbName)
+	return nil
+}
+
+// runArchiveRestore restores a mongodump --archive file given with --input
+func runArchiveRestore() error {
+	if restoreCollection != "" {
+		return fmt.Errorf("--collection cannot be used with a mongodump archive, use --collections")
+	}
+
+	manifest, err := backup.LoadArchive(inputFile)
+	if err != nil {
+		return err
+	}
+	entries, err := manifest.Select(splitList(restoreOnly))
+	if err != nil {
+		return err
+	}
+
+	if !skipConfirmation {
+		log.Printf("About to restore:")
+		log.Printf("  mongodump archive: %s (database '%s')", inputFile, manifest.Database)
+		log.Printf("  Target database: %s", dbName)
+		for _, entry := range entries {
+			log.Printf("  - %s", entry.Collection)
+		}
+		if dropExisting {
+			log.Printf("  WARNING: Existing collections will be DROPPED!")
+		}
+
+		if !confirmAction("Do you want to continue?") {
+			log.Println("Restore cancelled")
+			return nil
+		}
+	}
+
+	db, err := database.NewMongoDB(dbURI, dbName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer db.Close()
+
+	if err := backup.NewService(db).RestoreArchive(inputFile, splitList(restoreOnly), dropExisting, !noIndexes); err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	log.Printf("Restore completed successfully! Restored %d collections into '%s'", len(entries), dbName)
+	return nil
+}
+
 func confirmAction(message string) bool {
 	fmt.Printf("%s (y/N): ", message)
 	reader := bufio.NewReader(os.Stdin)
@@ -4,6 +4,8 @@ import (
 	"log"
 	"os"
 
+	"excelDisclaimer/internal/version"
+
 	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 )
@@ -13,6 +15,7 @@ var rootCmd = &cobra.Command{
 	Short: "A CLI tool for processing CSV files and importing to MongoDB",
 	Long: `CSV Processor is a command-line tool that helps you import CSV files 
 into MongoDB collections with support for Number field matching.`,
+	Version: version.Version,
 }
 
 func Execute() {
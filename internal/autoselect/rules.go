@@ -0,0 +1,113 @@
+package autoselect
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"excelDisclaimer/internal/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule sets AutoSelect to Value for records matching every condition given
+type Rule struct {
+	// Product is a regular expression matched against the Product field
+	Product string `yaml:"product"`
+	// NumberPrefix matches Numbers starting with this prefix
+	NumberPrefix string `yaml:"number_prefix"`
+	Value        string `yaml:"value"`
+
+	product *regexp.Regexp
+}
+
+// Resolver computes AutoSelect for records that do not carry a value from
+// the CSV. Rules are tried in order and the first match wins; records no
+// rule matches get the default.
+type Resolver struct {
+	rules    []*Rule
+	fallback string
+}
+
+// NewResolver compiles rules and uses fallback for records no rule matches
+func NewResolver(rules []Rule, fallback string) (*Resolver, error) {
+	r := &Resolver{fallback: fallback}
+	for i := range rules {
+		rule := rules[i]
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		r.rules = append(r.rules, &rule)
+	}
+	return r, nil
+}
+
+// LoadRules reads a YAML AutoSelect rules file of the form
+//
+//	rules:
+//	  - product: '(?i)^ladder'
+//	    value: "Yes"
+//	  - number_prefix: "99"
+//	    value: "No"
+func LoadRules(filename, fallback string) (*Resolver, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AutoSelect rules file: %w", err)
+	}
+
+	var config struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse AutoSelect rules file: %w", err)
+	}
+
+	r, err := NewResolver(config.Rules, fallback)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AutoSelect rules file %s: %w", filename, err)
+	}
+	return r, nil
+}
+
+func (r *Rule) compile() error {
+	if r.Product == "" && r.NumberPrefix == "" {
+		return fmt.Errorf("needs a product or number_prefix condition")
+	}
+	if r.Value == "" {
+		return fmt.Errorf("value is required")
+	}
+
+	if r.Product != "" {
+		product, err := regexp.Compile(r.Product)
+		if err != nil {
+			return fmt.Errorf("invalid product pattern: %w", err)
+		}
+		r.product = product
+	}
+	return nil
+}
+
+func (r *Rule) matches(record models.ProductRecord) bool {
+	if r.product != nil && !r.product.MatchString(record.Product) {
+		return false
+	}
+	if r.NumberPrefix != "" && !strings.HasPrefix(record.Number, r.NumberPrefix) {
+		return false
+	}
+	return true
+}
+
+// Apply fills in AutoSelect on record unless the CSV already set it
+func (r *Resolver) Apply(record *models.ProductRecord) {
+	if record.AutoSelect != "" {
+		return
+	}
+	for _, rule := range r.rules {
+		if rule.matches(*record) {
+			record.AutoSelect = rule.Value
+			return
+		}
+	}
+	record.AutoSelect = r.fallback
+}
@@ -0,0 +1,485 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"excelDisclaimer/internal/database"
+	"excelDisclaimer/internal/version"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// A mongodump --archive stream starts with archiveMagic and a header,
+// followed by a prelude of one metadata document per collection. The
+// documents follow in blocks, each a namespace header, the documents of
+// that namespace and a terminator. Every namespace ends with a header
+// marked EOF carrying the CRC-64 of all its documents.
+const (
+	archiveMagic         uint32 = 0x8199e26d
+	archiveFormatVersion        = "0.1"
+	archiveTerminator    uint32 = 0xffffffff
+)
+
+var archiveCRCTable = crc64.MakeTable(crc64.ECMA)
+
+type archiveHeader struct {
+	ConcurrentCollections int32  `bson:"concurrent_collections"`
+	FormatVersion         string `bson:"version"`
+	ServerVersion         string `bson:"server_version"`
+	ToolVersion           string `bson:"tool_version"`
+}
+
+// archiveCollection is the prelude entry of a collection; Metadata holds
+// the same Extended JSON as a .metadata.json file
+type archiveCollection struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	Metadata   string `bson:"metadata"`
+	Size       int64  `bson:"size"`
+	Type       string `bson:"type"`
+}
+
+type archiveNamespace struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+	CRC        int64  `bson:"CRC"`
+}
+
+// archiveSource is the part of the database an archive is written from
+type archiveSource interface {
+	CollectionMetadata(collectionName string) (*database.CollectionMetadata, error)
+	BackupCollection(collectionName string, writer io.Writer, format, jsonMode string) (int, error)
+}
+
+// archiveTarget is the part of the database an archive is restored into
+type archiveTarget interface {
+	DropCollection(collectionName string) error
+	ApplyCollectionMetadata(collectionName string, meta *database.CollectionMetadata, indexes bool) error
+	InsertDocuments(collectionName string, documents []interface{}) error
+}
+
+// writeArchive writes collections as a single mongodump archive at path and
+// adds an entry for each of them to manifest
+func (s *Service) writeArchive(path string, manifest *Manifest, collections []string, compression string) error {
+	if compression == "" {
+		compression = CompressNone
+	}
+
+	output, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer output.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{writer: io.MultiWriter(output, hash)}
+	writer, err := compressWriter(counter, compression)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	if err := writeArchiveStream(s.db, writer, manifest, collections); err != nil {
+		writer.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+
+	// Every collection lives in the same file
+	sum := hex.EncodeToString(hash.Sum(nil))
+	for i := range manifest.Collections {
+		manifest.Collections[i].File = filepath.Base(path)
+		manifest.Collections[i].Bytes = counter.count
+		manifest.Collections[i].SHA256 = sum
+		manifest.Collections[i].Compression = compression
+	}
+	return nil
+}
+
+// writeArchiveStream writes the archive of collections, read from source,
+// to writer and adds an entry for each of them to manifest
+func writeArchiveStream(source archiveSource, writer io.Writer, manifest *Manifest, collections []string) error {
+	if err := binary.Write(writer, binary.LittleEndian, archiveMagic); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	header := archiveHeader{
+		ConcurrentCollections: 1,
+		FormatVersion:         archiveFormatVersion,
+		ToolVersion:           version.Version,
+	}
+	if err := writeArchiveDocument(writer, header); err != nil {
+		return err
+	}
+
+	// The prelude lists every collection before any document is written
+	for _, collection := range collections {
+		meta, err := source.CollectionMetadata(collection)
+		if err != nil {
+			return err
+		}
+		data, err := bson.MarshalExtJSON(meta, true, false)
+		if err != nil {
+			return fmt.Errorf("failed to encode collection metadata: %w", err)
+		}
+		entry := archiveCollection{
+			Database:   manifest.Database,
+			Collection: collection,
+			Metadata:   string(data),
+			Type:       meta.Type,
+		}
+		if err := writeArchiveDocument(writer, entry); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(writer, binary.LittleEndian, archiveTerminator); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	for _, collection := range collections {
+		namespace := &namespaceWriter{
+			writer: writer,
+			header: archiveNamespace{Database: manifest.Database, Collection: collection},
+			crc:    crc64.New(archiveCRCTable),
+		}
+		count, err := source.BackupCollection(collection, namespace, "bson", "")
+		if err != nil {
+			return fmt.Errorf("failed to backup collection %s: %w", collection, err)
+		}
+		if err := namespace.close(); err != nil {
+			return err
+		}
+		manifest.Collections = append(manifest.Collections, ManifestEntry{
+			Collection: collection,
+			Documents:  count,
+			Format:     "bson",
+		})
+	}
+	return nil
+}
+
+// namespaceWriter frames the documents of one collection in an archive.
+// The block header is only written with the first document, so an empty
+// collection is just its EOF header.
+type namespaceWriter struct {
+	writer io.Writer
+	header archiveNamespace
+	crc    hash.Hash64
+	opened bool
+}
+
+func (w *namespaceWriter) Write(p []byte) (int, error) {
+	if !w.opened {
+		if err := writeArchiveDocument(w.writer, w.header); err != nil {
+			return 0, err
+		}
+		w.opened = true
+	}
+	w.crc.Write(p)
+	return w.writer.Write(p)
+}
+
+func (w *namespaceWriter) close() error {
+	if w.opened {
+		if err := binary.Write(w.writer, binary.LittleEndian, archiveTerminator); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+
+	eof := w.header
+	eof.EOF = true
+	eof.CRC = int64(w.crc.Sum64())
+	if err := writeArchiveDocument(w.writer, eof); err != nil {
+		return err
+	}
+	if err := binary.Write(w.writer, binary.LittleEndian, archiveTerminator); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+func writeArchiveDocument(writer io.Writer, value interface{}) error {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode archive header: %w", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// errTerminator marks the end of a prelude or of a namespace block
+var errTerminator = errors.New("archive terminator")
+
+// readArchiveDocument reads the next BSON document of an archive. It
+// returns errTerminator at a terminator and io.EOF at the end of the
+// stream.
+func readArchiveDocument(reader io.Reader) (bson.Raw, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(reader, length[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("corrupt archive: truncated document length")
+		}
+		return nil, err
+	}
+
+	size := binary.LittleEndian.Uint32(length[:])
+	if size == archiveTerminator {
+		return nil, errTerminator
+	}
+	if size < 5 {
+		return nil, fmt.Errorf("corrupt archive: invalid document length %d", size)
+	}
+
+	doc := make([]byte, size)
+	copy(doc, length[:])
+	if _, err := io.ReadFull(reader, doc[4:]); err != nil {
+		return nil, fmt.Errorf("corrupt archive: truncated document: %w", err)
+	}
+	if err := bson.Raw(doc).Validate(); err != nil {
+		return nil, fmt.Errorf("corrupt archive: %w", err)
+	}
+	return doc, nil
+}
+
+// IsArchive reports whether a file, possibly compressed, is a mongodump
+// archive
+func IsArchive(filename string) (bool, error) {
+	reader, err := openBackup(filename)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	var magic [4]byte
+	if _, err := io.ReadFull(reader, magic[:]); err != nil {
+		return false, nil
+	}
+	return binary.LittleEndian.Uint32(magic[:]) == archiveMagic, nil
+}
+
+// archive is an open mongodump archive positioned after its prelude
+type archive struct {
+	reader      io.ReadCloser
+	header      archiveHeader
+	database    string
+	collections map[string]archiveCollection
+	manifest    *Manifest
+}
+
+// openArchive reads the header and prelude of an archive. Collections of
+// the admin, config and local databases are ignored; the rest must belong
+// to a single database.
+func openArchive(filename string) (*archive, error) {
+	reader, err := openBackup(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &archive{reader: reader, collections: make(map[string]archiveCollection)}
+	if err := a.readPrelude(filename); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *archive) readPrelude(filename string) error {
+	var magic uint32
+	if err := binary.Read(a.reader, binary.LittleEndian, &magic); err != nil || magic != archiveMagic {
+		return fmt.Errorf("%s is not a mongodump archive", filename)
+	}
+
+	doc, err := readArchiveDocument(a.reader)
+	if err != nil {
+		return fmt.Errorf("failed to read archive header: %w", err)
+	}
+	if err := bson.Unmarshal(doc, &a.header); err != nil {
+		return fmt.Errorf("failed to decode archive header: %w", err)
+	}
+
+	databases := make(map[string]bool)
+	for {
+		doc, err := readArchiveDocument(a.reader)
+		if err == errTerminator {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive prelude: %w", err)
+		}
+
+		var entry archiveCollection
+		if err := bson.Unmarshal(doc, &entry); err != nil {
+			return fmt.Errorf("failed to decode archive prelude: %w", err)
+		}
+		if systemDatabases[entry.Database] || strings.HasPrefix(entry.Collection, "system.") || (entry.Type != "" && entry.Type != "collection") {
+			continue
+		}
+		databases[entry.Database] = true
+		a.database = entry.Database
+		a.collections[entry.Collection] = entry
+	}
+
+	if len(databases) == 0 {
+		return fmt.Errorf("archive %s holds no collections", filename)
+	}
+	if len(databases) > 1 {
+		var names []string
+		for name := range databases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("archive %s holds several databases (%s); only single database archives can be restored", filename, strings.Join(names, ", "))
+	}
+
+	a.manifest = &Manifest{ToolVersion: a.header.ToolVersion, Database: a.database}
+	for name := range a.collections {
+		a.manifest.Collections = append(a.manifest.Collections, ManifestEntry{
+			Collection: name,
+			File:       filepath.Base(filename),
+			Format:     "bson",
+		})
+	}
+	sort.Slice(a.manifest.Collections, func(i, j int) bool {
+		return a.manifest.Collections[i].Collection < a.manifest.Collections[j].Collection
+	})
+	return nil
+}
+
+// LoadArchive describes the collections of a mongodump archive
+func LoadArchive(filename string) (*Manifest, error) {
+	a, err := openArchive(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer a.reader.Close()
+	return a.manifest, nil
+}
+
+// RestoreArchive restores the collections of a mongodump archive, all of
+// them when collections is empty, into the connected database. Options and
+// indexes from the prelude are applied before any document is inserted.
+// Blocks of different collections may be interleaved, so documents are
+// batched per collection.
+func (s *Service) RestoreArchive(filename string, collections []string, dropExisting, indexes bool) error {
+	return restoreArchive(s.db, filename, collections, dropExisting, indexes)
+}
+
+func restoreArchive(target archiveTarget, filename string, collections []string, dropExisting, indexes bool) error {
+	a, err := openArchive(filename)
+	if err != nil {
+		return err
+	}
+	defer a.reader.Close()
+
+	entries, err := a.manifest.Select(collections)
+	if err != nil {
+		return err
+	}
+
+	const batchSize = 1000
+	batches := make(map[string][]interface{})
+	checksums := make(map[string]hash.Hash64)
+	counts := make(map[string]int)
+
+	for _, entry := range entries {
+		name := entry.Collection
+		if dropExisting {
+			if err := target.DropCollection(name); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+
+		var meta database.CollectionMetadata
+		if err := bson.UnmarshalExtJSON([]byte(a.collections[name].Metadata), false, &meta); err != nil {
+			return fmt.Errorf("failed to parse metadata of %s: %w", name, err)
+		}
+		if err := target.ApplyCollectionMetadata(name, &meta, indexes); err != nil {
+			return fmt.Errorf("failed to restore collection %s: %w", name, err)
+		}
+		checksums[name] = crc64.New(archiveCRCTable)
+	}
+
+	for {
+		doc, err := readArchiveDocument(a.reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		var namespace archiveNamespace
+		if err := bson.Unmarshal(doc, &namespace); err != nil {
+			return fmt.Errorf("failed to decode archive namespace header: %w", err)
+		}
+		name := namespace.Collection
+		checksum, selected := checksums[name]
+		selected = selected && namespace.Database == a.database
+
+		for {
+			doc, err := readArchiveDocument(a.reader)
+			if err == errTerminator {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read documents of %s.%s: %w", namespace.Database, name, err)
+			}
+			if !selected {
+				continue
+			}
+
+			checksum.Write(doc)
+			counts[name]++
+			batches[name] = append(batches[name], doc)
+			if len(batches[name]) >= batchSize {
+				if err := target.InsertDocuments(name, batches[name]); err != nil {
+					return fmt.Errorf("failed to restore collection %s: %w", name, err)
+				}
+				batches[name] = nil
+			}
+		}
+
+		if !selected || !namespace.EOF {
+			continue
+		}
+		if uint64(namespace.CRC) != checksum.Sum64() {
+			return fmt.Errorf("archive checksum mismatch for collection %s", name)
+		}
+		if len(batches[name]) > 0 {
+			if err := target.InsertDocuments(name, batches[name]); err != nil {
+				return fmt.Errorf("failed to restore collection %s: %w", name, err)
+			}
+			batches[name] = nil
+		}
+		log.Printf("Restore completed: imported %d documents to collection '%s'", counts[name], name)
+		delete(checksums, name)
+	}
+
+	if len(checksums) > 0 {
+		var missing []string
+		for name := range checksums {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("archive %s ended before the end of %s", filename, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"excelDisclaimer/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// fakeDatabase stands in for MongoDB on both sides of an archive
+type fakeDatabase struct {
+	metadata  map[string]*database.CollectionMetadata
+	documents map[string][]bson.D
+
+	dropped  []string
+	applied  map[string]*database.CollectionMetadata
+	inserted map[string][]bson.Raw
+	inserts  int
+}
+
+func newFakeDatabase() *fakeDatabase {
+	return &fakeDatabase{
+		metadata:  make(map[string]*database.CollectionMetadata),
+		documents: make(map[string][]bson.D),
+		applied:   make(map[string]*database.CollectionMetadata),
+		inserted:  make(map[string][]bson.Raw),
+	}
+}
+
+func (f *fakeDatabase) CollectionMetadata(collectionName string) (*database.CollectionMetadata, error) {
+	if meta, ok := f.metadata[collectionName]; ok {
+		return meta, nil
+	}
+	return &database.CollectionMetadata{CollectionName: collectionName, Type: "collection"}, nil
+}
+
+func (f *fakeDatabase) BackupCollection(collectionName string, writer io.Writer, format, jsonMode string) (int, error) {
+	for _, doc := range f.documents[collectionName] {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(f.documents[collectionName]), nil
+}
+
+func (f *fakeDatabase) DropCollection(collectionName string) error {
+	f.dropped = append(f.dropped, collectionName)
+	return nil
+}
+
+func (f *fakeDatabase) ApplyCollectionMetadata(collectionName string, meta *database.CollectionMetadata, indexes bool) error {
+	f.applied[collectionName] = meta
+	return nil
+}
+
+func (f *fakeDatabase) InsertDocuments(collectionName string, documents []interface{}) error {
+	f.inserts++
+	for _, doc := range documents {
+		f.inserted[collectionName] = append(f.inserted[collectionName], doc.(bson.Raw))
+	}
+	return nil
+}
+
+func numberedDocuments(n int) []bson.D {
+	docs := make([]bson.D, n)
+	for i := range docs {
+		docs[i] = bson.D{{Key: "n", Value: int32(i)}, {Key: "Number", Value: "N" + strings.Repeat("0", i%5)}}
+	}
+	return docs
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, compression := range []string{CompressNone, CompressGzip, CompressZstd} {
+		t.Run(compression, func(t *testing.T) {
+			source := newFakeDatabase()
+			source.documents["records"] = numberedDocuments(2500)
+			source.metadata["records"] = &database.CollectionMetadata{
+				CollectionName: "records",
+				Type:           "collection",
+				Options:        bson.D{},
+				Indexes: []bson.D{
+					{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "_id", Value: int32(1)}}}, {Key: "name", Value: "_id_"}},
+					{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "Number", Value: int32(1)}}}, {Key: "name", Value: "Number_1"}},
+				},
+			}
+
+			filename := filepath.Join(t.TempDir(), "backup.archive"+compressionExtensions[compression])
+			file, err := os.Create(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer, err := compressWriter(file, compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifest := &Manifest{Database: "testdb"}
+			if err := writeArchiveStream(source, writer, manifest, []string{"records", "empty"}); err != nil {
+				t.Fatalf("writeArchiveStream: %v", err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			if got := manifest.Collections; len(got) != 2 || got[0].Documents != 2500 || got[1].Documents != 0 {
+				t.Fatalf("manifest collections = %+v", got)
+			}
+
+			loaded, err := LoadArchive(filename)
+			if err != nil {
+				t.Fatalf("LoadArchive: %v", err)
+			}
+			if loaded.Database != "testdb" || len(loaded.Collections) != 2 ||
+				loaded.Collections[0].Collection != "empty" || loaded.Collections[1].Collection != "records" {
+				t.Fatalf("loaded manifest = %+v", loaded)
+			}
+
+			target := newFakeDatabase()
+			if err := restoreArchive(target, filename, nil, true, true); err != nil {
+				t.Fatalf("restoreArchive: %v", err)
+			}
+
+			if len(target.dropped) != 2 {
+				t.Errorf("dropped = %v, want both collections", target.dropped)
+			}
+			if meta := target.applied["records"]; meta == nil || len(meta.Indexes) != 2 {
+				t.Errorf("records metadata = %+v, want 2 indexes", meta)
+			}
+			if _, ok := target.applied["empty"]; !ok {
+				t.Errorf("metadata of the empty collection was not applied")
+			}
+			if len(target.inserted["empty"]) != 0 {
+				t.Errorf("inserted %d documents into the empty collection", len(target.inserted["empty"]))
+			}
+			restored := target.inserted["records"]
+			if len(restored) != 2500 {
+				t.Fatalf("restored %d documents, want 2500", len(restored))
+			}
+			for i, doc := range restored {
+				if n := doc.Lookup("n").Int32(); n != int32(i) {
+					t.Fatalf("document %d has n = %d", i, n)
+				}
+			}
+			if target.inserts != 3 {
+				t.Errorf("restored in %d batches, want 3", target.inserts)
+			}
+		})
+	}
+}
+
+// archiveBuilder assembles archives by hand for the layouts and faults
+// writeArchiveStream does not produce
+type archiveBuilder struct {
+	t         *testing.T
+	buf       bytes.Buffer
+	checksums map[string]hash.Hash64
+}
+
+func newArchiveBuilder(t *testing.T, collections ...archiveCollection) *archiveBuilder {
+	b := &archiveBuilder{t: t, checksums: make(map[string]hash.Hash64)}
+	binary.Write(&b.buf, binary.LittleEndian, archiveMagic)
+	b.document(archiveHeader{ConcurrentCollections: 1, FormatVersion: archiveFormatVersion})
+	for _, collection := range collections {
+		if collection.Metadata == "" {
+			collection.Metadata = `{"options":{},"indexes":[]}`
+		}
+		b.document(collection)
+	}
+	b.terminator()
+	return b
+}
+
+func (b *archiveBuilder) document(value interface{}) []byte {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	b.buf.Write(data)
+	return data
+}
+
+func (b *archiveBuilder) terminator() {
+	binary.Write(&b.buf, binary.LittleEndian, archiveTerminator)
+}
+
+// block writes a namespace block holding documents numbered from first
+func (b *archiveBuilder) block(db, collection string, first, count int) *archiveBuilder {
+	ns := db + "." + collection
+	if b.checksums[ns] == nil {
+		b.checksums[ns] = crc64.New(archiveCRCTable)
+	}
+	b.document(archiveNamespace{Database: db, Collection: collection})
+	for i := first; i < first+count; i++ {
+		b.checksums[ns].Write(b.document(bson.D{{Key: "n", Value: int32(i)}}))
+	}
+	b.terminator()
+	return b
+}
+
+// eof ends a namespace with the CRC of the documents written to it
+func (b *archiveBuilder) eof(db, collection string) *archiveBuilder {
+	var crc uint64
+	if checksum := b.checksums[db+"."+collection]; checksum != nil {
+		crc = checksum.Sum64()
+	}
+	return b.eofCRC(db, collection, crc)
+}
+
+func (b *archiveBuilder) eofCRC(db, collection string, crc uint64) *archiveBuilder {
+	b.document(archiveNamespace{Database: db, Collection: collection, EOF: true, CRC: int64(crc)})
+	b.terminator()
+	return b
+}
+
+func (b *archiveBuilder) truncate(n int) *archiveBuilder {
+	b.buf.Truncate(b.buf.Len() - n)
+	return b
+}
+
+func TestRestoreArchiveFraming(t *testing.T) {
+	a := archiveCollection{Database: "testdb", Collection: "a", Type: "collection"}
+	bc := archiveCollection{Database: "testdb", Collection: "b", Type: "collection"}
+
+	tests := []struct {
+		name        string
+		archive     func(t *testing.T) []byte
+		collections []string
+		want        map[string]int
+		wantErr     string
+	}{
+		{
+			name: "interleaved blocks",
+			archive: func(t *testing.T) []byte {
+				b := newArchiveBuilder(t, a, bc).
+					block("testdb", "a", 0, 2).
+					block("testdb", "b", 0, 1).
+					block("testdb", "a", 2, 3).
+					eof("testdb", "a").
+					eof("testdb", "b")
+				return b.buf.Bytes()
+			},
+			want: map[string]int{"a": 5, "b": 1},
+		},
+		{
+			name: "empty collection",
+			archive: func(t *testing.T) []byte {
+				return newArchiveBuilder(t, a).eof("testdb", "a").buf.Bytes()
+			},
+			want: map[string]int{"a": 0},
+		},
+		{
+			name: "system databases and collections skipped",
+			archive: func(t *testing.T) []byte {
+				b := newArchiveBuilder(t,
+					archiveCollection{Database: "admin", Collection: "system.users"},
+					archiveCollection{Database: "config", Collection: "settings"},
+					archiveCollection{Database: "testdb", Collection: "system.views"},
+					archiveCollection{Database: "testdb", Collection: "view", Type: "view"},
+					a,
+				).
+					block("admin", "system.users", 0, 2).
+					eof("admin", "system.users").
+					block("config", "settings", 0, 1).
+					eof("config", "settings").
+					block("testdb", "a", 0, 3).
+					eof("testdb", "a")
+				return b.buf.Bytes()
+			},
+			want: map[string]int{"a": 3},
+		},
+		{
+			name: "unselected collection skipped",
+			archive: func(t *testing.T) []byte {
+				b := newArchiveBuilder(t, a, bc).
+					block("testdb", "b", 0, 4).
+					eof("testdb", "b").
+					block("testdb", "a", 0, 1).
+					eof("testdb", "a")
+				return b.buf.Bytes()
+			},
+			collections: []string{"a"},
+			want:        map[string]int{"a": 1},
+		},
+		{
+			name: "checksum mismatch",
+			archive: func(t *testing.T) []byte {
+				return newArchiveBuilder(t, a).block("testdb", "a", 0, 2).eofCRC("testdb", "a", 42).buf.Bytes()
+			},
+			wantErr: "archive checksum mismatch for collection a",
+		},
+		{
+			name: "missing EOF header",
+			archive: func(t *testing.T) []byte {
+				return newArchiveBuilder(t, a, bc).block("testdb", "a", 0, 2).eof("testdb", "a").buf.Bytes()
+			},
+			wantErr: "ended before the end of b",
+		},
+		{
+			name: "truncated document",
+			archive: func(t *testing.T) []byte {
+				return newArchiveBuilder(t, a).block("testdb", "a", 0, 2).truncate(7).buf.Bytes()
+			},
+			wantErr: "truncated document",
+		},
+		{
+			name: "truncated document length",
+			archive: func(t *testing.T) []byte {
+				return newArchiveBuilder(t, a).block("testdb", "a", 0, 2).truncate(2).buf.Bytes()
+			},
+			wantErr: "truncated document length",
+		},
+		{
+			name: "several databases",
+			archive: func(t *testing.T) []byte {
+				return newArchiveBuilder(t, a, archiveCollection{Database: "otherdb", Collection: "c"}).buf.Bytes()
+			},
+			wantErr: "several databases (otherdb, testdb)",
+		},
+		{
+			name: "only system databases",
+			archive: func(t *testing.T) []byte {
+				return newArchiveBuilder(t, archiveCollection{Database: "local", Collection: "oplog.rs"}).buf.Bytes()
+			},
+			wantErr: "holds no collections",
+		},
+		{
+			name: "not an archive",
+			archive: func(t *testing.T) []byte {
+				return []byte(`{"Number":"1"}`)
+			},
+			wantErr: "is not a mongodump archive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "dump.archive")
+			if err := os.WriteFile(filename, tt.archive(t), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			target := newFakeDatabase()
+			err := restoreArchive(target, filename, tt.collections, false, true)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("restoreArchive error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("restoreArchive: %v", err)
+			}
+
+			if len(target.applied) != len(tt.want) {
+				t.Errorf("metadata applied to %d collections, want %d", len(target.applied), len(tt.want))
+			}
+			for name, count := range tt.want {
+				docs := target.inserted[name]
+				if len(docs) != count {
+					t.Fatalf("restored %d documents into %s, want %d", len(docs), name, count)
+				}
+				for i, doc := range docs {
+					if n := doc.Lookup("n").Int32(); n != int32(i) {
+						t.Errorf("%s document %d has n = %d", name, i, n)
+					}
+				}
+			}
+			for name := range target.inserted {
+				if _, ok := tt.want[name]; !ok {
+					t.Errorf("unexpected documents restored into %s", name)
+				}
+			}
+		})
+	}
+}
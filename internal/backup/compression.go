@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported backup compressions
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressionExtensions are appended to the format extension, e.g.
+// backup_records_20240101_120000.bson.gz
+var compressionExtensions = map[string]string{
+	CompressGzip: ".gz",
+	CompressZstd: ".zst",
+}
+
+// ValidateCompression reports whether compression can be used in Options;
+// an empty value means none
+func ValidateCompression(compression string) error {
+	switch compression {
+	case "", CompressNone, CompressGzip, CompressZstd:
+		return nil
+	}
+	return fmt.Errorf("invalid compression: %s. Use 'none', 'gzip' or 'zstd'", compression)
+}
+
+// compressWriter wraps w so that everything written to it is compressed.
+// Closing the returned writer flushes the compressed stream but leaves w
+// open.
+func compressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressGzip:
+		return gzip.NewWriter(w), nil
+	case CompressZstd:
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		return encoder, nil
+	}
+	return nopWriteCloser{w}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// detectCompression identifies the compression of a stream from its magic
+// bytes without consuming them
+func detectCompression(reader *bufio.Reader) string {
+	magic, _ := reader.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return CompressGzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		return CompressZstd
+	}
+	return CompressNone
+}
+
+// openBackup opens a backup file and transparently decompresses it,
+// whatever its extension says
+func openBackup(filename string) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup file: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+	switch detectCompression(reader) {
+	case CompressGzip:
+		decoder, err := gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read gzip backup: %w", err)
+		}
+		return &decompressReader{Reader: decoder, closers: []io.Closer{decoder, file}}, nil
+	case CompressZstd:
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read zstd backup: %w", err)
+		}
+		return &decompressReader{Reader: decoder, closers: []io.Closer{zstdCloser{decoder}, file}}, nil
+	}
+	return &decompressReader{Reader: reader, closers: []io.Closer{file}}, nil
+}
+
+// decompressReader reads the decompressed stream and closes the decoder
+// and the underlying file together
+type decompressReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *decompressReader) Close() error {
+	var first error
+	for _, closer := range r.closers {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type zstdCloser struct {
+	decoder *zstd.Decoder
+}
+
+func (c zstdCloser) Close() error {
+	c.decoder.Close()
+	return nil
+}
+
+// trimCompressionExtension removes a trailing .gz, .zst or .zstd so the
+// format extension underneath can be read
+func trimCompressionExtension(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz", ".zst", ".zstd":
+		return strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+	return filename
+}
+
+// DetectFormat works out whether a possibly compressed backup file holds
+// BSON or JSON, from its extension or, failing that, from its content
+func DetectFormat(filename string) (string, error) {
+	switch strings.ToLower(filepath.Ext(trimCompressionExtension(filename))) {
+	case ".bson":
+		return "bson", nil
+	case ".json":
+		return "json", nil
+	}
+
+	reader, err := openBackup(filename)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	format, err := sniffFormat(reader)
+	if err != nil {
+		return "", fmt.Errorf("cannot detect format of %s: %w", filename, err)
+	}
+	return format, nil
+}
+
+// errEmptyBackup is returned by sniffFormat for a stream without data
+var errEmptyBackup = errors.New("backup file is empty")
+
+// sniffFormat tells BSON from JSON by the first bytes of a decompressed
+// backup stream. JSON backups hold one document per line, so they start
+// with '{'. A BSON document starts with its little-endian int32 length,
+// whose last byte is zero for any document under 16MB.
+func sniffFormat(reader io.Reader) (string, error) {
+	sample := make([]byte, 4)
+	n, err := io.ReadFull(reader, sample)
+	if n == 0 {
+		if err == io.EOF {
+			return "", errEmptyBackup
+		}
+		return "", err
+	}
+	if sample[0] == '{' && (n < len(sample) || sample[3] != 0) {
+		return "json", nil
+	}
+	if n == len(sample) && sample[3] == 0 && binary.LittleEndian.Uint32(sample) >= 5 {
+		return "bson", nil
+	}
+	return "", fmt.Errorf("data is neither BSON nor JSON documents")
+}
@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestFile is the name of the manifest written into every backup set
+const ManifestFile = "manifest.json"
+
+// Manifest ties the files of one backup run together as a set
+type Manifest struct {
+	ToolVersion string          `json:"tool_version"`
+	Database    string          `json:"database"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Collections []ManifestEntry `json:"collections"`
+}
+
+// ManifestEntry describes the backup file of a single collection. File is
+// relative to the directory holding the manifest.
+type ManifestEntry struct {
+	Collection  string `json:"collection"`
+	File        string `json:"file"`
+	Documents   int    `json:"documents"`
+	Bytes       int64  `json:"bytes"`
+	SHA256      string `json:"sha256"`
+	Format      string `json:"format"`
+	Compression string `json:"compression"`
+	// JSONMode is canonical or relaxed Extended JSON for the json format
+	JSONMode string `json:"json_mode,omitempty"`
+	// Metadata is the sidecar holding the collection options and indexes
+	Metadata string `json:"metadata,omitempty"`
+}
+
+// LoadManifest reads a manifest from its path or from the backup set
+// directory holding it
+func LoadManifest(path string) (*Manifest, string, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, ManifestFile)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, "", fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	if len(manifest.Collections) == 0 {
+		return nil, "", fmt.Errorf("manifest %s lists no collections", path)
+	}
+	return &manifest, filepath.Dir(path), nil
+}
+
+func (m *Manifest) write(dir string) (string, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	path := filepath.Join(dir, ManifestFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return path, nil
+}
+
+// Select returns the entries for the named collections, in manifest order,
+// or every entry when names is empty
+func (m *Manifest) Select(names []string) ([]ManifestEntry, error) {
+	if len(names) == 0 {
+		return m.Collections, nil
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var selected []ManifestEntry
+	for _, entry := range m.Collections {
+		if wanted[entry.Collection] {
+			selected = append(selected, entry)
+			delete(wanted, entry.Collection)
+		}
+	}
+	for _, name := range names {
+		if wanted[name] {
+			return nil, fmt.Errorf("collection %s is not in the backup set", name)
+		}
+	}
+	return selected, nil
+}
+
+// Verify checks that the file of entry in dir still has the recorded size
+// and checksum
+func (e ManifestEntry) Verify(dir string) error {
+	path := filepath.Join(dir, e.File)
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open backup file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if size != e.Bytes {
+		return fmt.Errorf("%s is %d bytes, the manifest expects %d", path, size, e.Bytes)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != e.SHA256 {
+		return fmt.Errorf("%s has checksum %s, the manifest expects %s", path, sum, e.SHA256)
+	}
+	return nil
+}
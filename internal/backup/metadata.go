@@ -0,0 +1,85 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"excelDisclaimer/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// metadataExtension names the sidecar written next to each backup file,
+// e.g. backup_records_20240101_120000.metadata.json
+const metadataExtension = ".metadata.json"
+
+// metadataPath returns the sidecar path for a backup file
+func metadataPath(backupFile string) string {
+	base := trimCompressionExtension(backupFile)
+	switch strings.ToLower(filepath.Ext(base)) {
+	case ".bson", ".json":
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return base + metadataExtension
+}
+
+// writeMetadata saves the options and indexes of a collection as canonical
+// Extended JSON, so types such as Int64 sizes survive the round trip.
+// mongodump gzips its sidecars along with the data, so compression applies
+// to the file as a whole.
+func writeMetadata(path string, meta *database.CollectionMetadata, compression string) error {
+	data, err := bson.MarshalExtJSONIndent(meta, true, false, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode collection metadata: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write collection metadata: %w", err)
+	}
+	defer file.Close()
+
+	writer, err := compressWriter(file, compression)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write collection metadata: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to write collection metadata: %w", err)
+	}
+	return nil
+}
+
+// readMetadata loads a sidecar, or its gzipped .gz variant as written by
+// mongodump --gzip, returning nil without an error when the backup has none
+func readMetadata(path string) (*database.CollectionMetadata, error) {
+	reader, err := openBackup(path)
+	if errors.Is(err, os.ErrNotExist) {
+		path += compressionExtensions[CompressGzip]
+		reader, err = openBackup(path)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collection metadata: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collection metadata: %w", err)
+	}
+
+	var meta database.CollectionMetadata
+	if err := bson.UnmarshalExtJSON(data, false, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse collection metadata %s: %w", path, err)
+	}
+	return &meta, nil
+}
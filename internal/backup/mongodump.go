@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Backup layouts
+const (
+	// LayoutSet writes timestamped files next to a manifest.json
+	LayoutSet = "set"
+	// LayoutMongodump writes <db>/<collection>.bson and
+	// <collection>.metadata.json as mongodump does
+	LayoutMongodump = "mongodump"
+)
+
+// ValidateLayout reports whether a layout can be used with the given format
+// and compression; mongorestore only reads BSON, optionally gzipped
+func ValidateLayout(layout, format, compression string, archive bool) error {
+	switch layout {
+	case "", LayoutSet:
+		if archive {
+			return fmt.Errorf("--archive requires the mongodump layout")
+		}
+		return nil
+	case LayoutMongodump:
+	default:
+		return fmt.Errorf("invalid layout: %s. Use 'set' or 'mongodump'", layout)
+	}
+
+	if format != "bson" {
+		return fmt.Errorf("the mongodump layout only supports the bson format")
+	}
+	if compression == CompressZstd {
+		return fmt.Errorf("the mongodump layout only supports gzip compression")
+	}
+	return nil
+}
+
+// dumpFileName returns the data file of a collection in the mongodump
+// layout, relative to the dump directory
+func dumpFileName(databaseName, collectionName, compression string) string {
+	return filepath.Join(databaseName, escapeDumpName(collectionName)+".bson"+compressionExtensions[compression])
+}
+
+// escapeDumpName escapes the characters mongodump does not allow in file
+// names, the same way mongodump does
+func escapeDumpName(name string) string {
+	name = strings.ReplaceAll(name, "%", "%25")
+	return strings.ReplaceAll(name, "/", "%2F")
+}
+
+// systemDatabases are left out when a whole mongodump is restored into a
+// single database
+var systemDatabases = map[string]bool{"admin": true, "config": true, "local": true}
+
+// LoadDump describes a directory written by mongodump, or by backup
+// --layout mongodump, as a manifest without checksums. path is either the
+// dump directory holding one database directory or that database
+// directory itself. The returned directory is the one entry files are
+// relative to.
+func LoadDump(path string) (*Manifest, string, error) {
+	entries, err := dumpCollections(path, "")
+	if err != nil {
+		return nil, "", err
+	}
+	if len(entries) > 0 {
+		return &Manifest{Database: filepath.Base(path), Collections: entries}, path, nil
+	}
+
+	dirs, err := os.ReadDir(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read dump directory: %w", err)
+	}
+
+	var databases []string
+	var manifest *Manifest
+	for _, dir := range dirs {
+		if !dir.IsDir() || systemDatabases[dir.Name()] {
+			continue
+		}
+		entries, err := dumpCollections(filepath.Join(path, dir.Name()), dir.Name())
+		if err != nil {
+			return nil, "", err
+		}
+		if len(entries) > 0 {
+			databases = append(databases, dir.Name())
+			manifest = &Manifest{Database: dir.Name(), Collections: entries}
+		}
+	}
+
+	switch len(databases) {
+	case 0:
+		return nil, "", fmt.Errorf("no mongodump collections found in %s", path)
+	case 1:
+		return manifest, path, nil
+	}
+	return nil, "", fmt.Errorf("%s holds dumps of several databases (%s); pass the directory of the one to restore", path, strings.Join(databases, ", "))
+}
+
+// dumpCollections lists the collection files of a mongodump database
+// directory, with paths prefixed by prefix
+func dumpCollections(dir, prefix string) ([]ManifestEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dump directory: %w", err)
+	}
+
+	var entries []ManifestEntry
+	for _, file := range files {
+		name := file.Name()
+		base := trimCompressionExtension(name)
+		// oplog.bson holds the oplog written by mongodump --oplog next to
+		// the database directories
+		if file.IsDir() || filepath.Ext(base) != ".bson" || base == "oplog.bson" {
+			continue
+		}
+
+		collection, err := url.PathUnescape(strings.TrimSuffix(base, ".bson"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid collection file name %s: %w", name, err)
+		}
+		if strings.HasPrefix(collection, "system.") {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		compression := CompressNone
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".gz":
+			compression = CompressGzip
+		case ".zst", ".zstd":
+			compression = CompressZstd
+		}
+		entries = append(entries, ManifestEntry{
+			Collection:  collection,
+			File:        filepath.Join(prefix, name),
+			Bytes:       info.Size(),
+			Format:      "bson",
+			Compression: compression,
+			Metadata:    metadataPath(filepath.Join(prefix, name)),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Collection < entries[j].Collection })
+	return entries, nil
+}
+
+// RestoreDump restores the collections of a mongodump directory, all of
+// them when collections is empty, into the connected database
+func (s *Service) RestoreDump(path string, collections []string, dropExisting, indexes bool) error {
+	manifest, dir, err := LoadDump(path)
+	if err != nil {
+		return err
+	}
+
+	entries, err := manifest.Select(collections)
+	if err != nil {
+		return err
+	}
+	return s.restoreEntries(dir, entries, dropExisting, indexes)
+}
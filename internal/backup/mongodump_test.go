@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEscapeDumpName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"records", "records"},
+		{"records.archive", "records.archive"},
+		{"a/b", "a%2Fb"},
+		{"100%", "100%25"},
+		{"%2F", "%252F"},
+		{"a/%/b", "a%2F%25%2Fb"},
+		{"名前 with spaces", "名前 with spaces"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := escapeDumpName(tt.name)
+			if got != tt.want {
+				t.Errorf("escapeDumpName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+			back, err := url.PathUnescape(got)
+			if err != nil {
+				t.Fatalf("PathUnescape(%q): %v", got, err)
+			}
+			if back != tt.name {
+				t.Errorf("PathUnescape(%q) = %q, want %q", got, back, tt.name)
+			}
+		})
+	}
+}
+
+func TestDumpFileName(t *testing.T) {
+	tests := []struct {
+		collection  string
+		compression string
+		want        string
+	}{
+		{"records", CompressNone, filepath.Join("testdb", "records.bson")},
+		{"records", CompressGzip, filepath.Join("testdb", "records.bson.gz")},
+		{"a/b", CompressGzip, filepath.Join("testdb", "a%2Fb.bson.gz")},
+	}
+
+	for _, tt := range tests {
+		if got := dumpFileName("testdb", tt.collection, tt.compression); got != tt.want {
+			t.Errorf("dumpFileName(%q, %q) = %q, want %q", tt.collection, tt.compression, got, tt.want)
+		}
+	}
+}
+
+func TestLoadDump(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       []string
+		path        string
+		wantDB      string
+		wantFiles   []string
+		wantErr     string
+		collections []string
+	}{
+		{
+			name:        "dump directory",
+			files:       []string{"testdb/records.bson.gz", "testdb/records.metadata.json.gz", "testdb/a%2Fb.bson", "admin/system.users.bson", "oplog.bson"},
+			wantDB:      "testdb",
+			collections: []string{"a/b", "records"},
+			wantFiles:   []string{filepath.Join("testdb", "a%2Fb.bson"), filepath.Join("testdb", "records.bson.gz")},
+		},
+		{
+			name:        "database directory",
+			files:       []string{"testdb/records.bson", "testdb/system.views.bson"},
+			path:        "testdb",
+			wantDB:      "testdb",
+			collections: []string{"records"},
+			wantFiles:   []string{"records.bson"},
+		},
+		{
+			name:    "several databases",
+			files:   []string{"one/a.bson", "two/b.bson", "local/oplog.rs.bson"},
+			wantErr: "several databases (one, two)",
+		},
+		{
+			name:    "no collections",
+			files:   []string{"admin/users.bson", "notes.txt"},
+			wantErr: "no mongodump collections found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, file := range tt.files {
+				path := filepath.Join(dir, filepath.FromSlash(file))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			manifest, _, err := LoadDump(filepath.Join(dir, tt.path))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadDump error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadDump: %v", err)
+			}
+
+			if manifest.Database != tt.wantDB {
+				t.Errorf("database = %q, want %q", manifest.Database, tt.wantDB)
+			}
+			if len(manifest.Collections) != len(tt.collections) {
+				t.Fatalf("collections = %+v, want %v", manifest.Collections, tt.collections)
+			}
+			for i, entry := range manifest.Collections {
+				if entry.Collection != tt.collections[i] || entry.File != tt.wantFiles[i] {
+					t.Errorf("entry %d = %s in %s, want %s in %s", i, entry.Collection, entry.File, tt.collections[i], tt.wantFiles[i])
+				}
+			}
+		})
+	}
+}
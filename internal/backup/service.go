@@ -1,12 +1,18 @@
 package backup
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"excelDisclaimer/internal/database"
+	"excelDisclaimer/internal/version"
 )
 
 type Service struct {
@@ -17,97 +23,290 @@ func NewService(db *database.MongoDB) *Service {
 	return &Service{db: db}
 }
 
-func (s *Service) BackupCollection(collectionName, outputDir, format string) (string, error) {
+// Options select how backup files are written
+type Options struct {
+	// Format is bson or json
+	Format string
+	// Compression is none, gzip or zstd; empty means none
+	Compression string
+	// JSONMode picks canonical or relaxed Extended JSON for the json
+	// format; empty means canonical
+	JSONMode string
+	// Layout is set, the default, or mongodump
+	Layout string
+	// Archive writes the mongodump layout as a single --archive stream
+	Archive bool
+}
+
+// BackupCollection writes every document of a collection to a timestamped
+// file in outputDir
+func (s *Service) BackupCollection(collectionName, outputDir string, opts Options) (string, error) {
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	timestamp := time.Now().Format("20060102_150405")
+	entry, err := s.backupCollection(collectionName, outputDir, backupFileName(collectionName, opts), opts)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(outputDir, entry.File), nil
+}
+
+// backupFileName returns the timestamped file name of a collection backup,
+// e.g. backup_records_20240101_120000.bson.gz
+func backupFileName(collectionName string, opts Options) string {
 	extension := "bson"
-	if format == "json" {
+	if opts.Format == "json" {
 		extension = "json"
 	}
+	return fmt.Sprintf("backup_%s_%s.%s%s", collectionName, time.Now().Format("20060102_150405"), extension, compressionExtensions[opts.Compression])
+}
 
-	filename := fmt.Sprintf("backup_%s_%s.%s", collectionName, timestamp, extension)
-	filepath := filepath.Join(outputDir, filename)
+// backupCollection writes the backup of a collection to file, relative to
+// dir, and describes it for the manifest
+func (s *Service) backupCollection(collectionName, dir, file string, opts Options) (ManifestEntry, error) {
+	extension := "bson"
+	if opts.Format == "json" {
+		extension = "json"
+	}
+	compression := opts.Compression
+	if compression == "" {
+		compression = CompressNone
+	}
+
+	entry := ManifestEntry{
+		Collection:  collectionName,
+		File:        file,
+		Format:      extension,
+		Compression: compression,
+	}
+	if extension == "json" {
+		entry.JSONMode = opts.JSONMode
+		if entry.JSONMode == "" {
+			entry.JSONMode = database.JSONCanonical
+		}
+	}
+	path := filepath.Join(dir, entry.File)
 
-	file, err := os.Create(filepath)
+	output, err := os.Create(path)
 	if err != nil {
-		return "", fmt.Errorf("failed to create backup file: %w", err)
+		return entry, fmt.Errorf("failed to create backup file: %w", err)
 	}
-	defer file.Close()
+	defer output.Close()
 
-	if err := s.db.BackupCollection(collectionName, file, format); err != nil {
-		os.Remove(filepath)
-		return "", fmt.Errorf("backup failed: %w", err)
+	// The checksum and size cover the file as stored, after compression
+	hash := sha256.New()
+	counter := &countingWriter{writer: io.MultiWriter(output, hash)}
+
+	writer, err := compressWriter(counter, compression)
+	if err != nil {
+		os.Remove(path)
+		return entry, err
 	}
 
-	return filepath, nil
-}
+	entry.Documents, err = s.db.BackupCollection(collectionName, writer, extension, entry.JSONMode)
+	if err != nil {
+		writer.Close()
+		os.Remove(path)
+		return entry, fmt.Errorf("backup failed: %w", err)
+	}
+
+	// Closing the compressor writes the end of the compressed stream
+	if err := writer.Close(); err != nil {
+		os.Remove(path)
+		return entry, fmt.Errorf("failed to finish backup file: %w", err)
+	}
 
-func (s *Service) BackupDatabase(outputDir, format string) ([]string, error) {
-	collections, err := s.db.ListCollections()
+	entry.Bytes = counter.count
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	// Indexes, validators and other collection options go to a sidecar
+	meta, err := s.db.CollectionMetadata(collectionName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list collections: %w", err)
+		return entry, err
+	}
+	// mongodump compresses the sidecar too; the set layout keeps it
+	// readable
+	entry.Metadata = metadataPath(entry.File)
+	metaCompression := CompressNone
+	if opts.Layout == LayoutMongodump {
+		entry.Metadata += compressionExtensions[compression]
+		metaCompression = compression
+	}
+	if err := writeMetadata(filepath.Join(dir, entry.Metadata), meta, metaCompression); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// countingWriter counts the bytes passed through to writer
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
+
+// BackupDatabase writes a backup set: a directory under outputDir holding
+// one file per collection and a manifest.json describing them. Every
+// collection is included when collections is empty. It returns the path of
+// the manifest, or of the archive when opts.Archive is set.
+func (s *Service) BackupDatabase(outputDir string, opts Options, collections []string) (string, *Manifest, error) {
+	if len(collections) == 0 {
+		all, err := s.db.ListCollections()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to list collections: %w", err)
+		}
+		for _, collection := range all {
+			if collection != "system.indexes" {
+				collections = append(collections, collection)
+			}
+		}
 	}
 
 	if len(collections) == 0 {
-		return nil, fmt.Errorf("no collections found in database")
+		return "", nil, fmt.Errorf("no collections found in database")
 	}
 
-	var backupFiles []string
-	for _, collection := range collections {
-		if collection == "system.indexes" {
-			continue
+	databaseName := s.db.Database.Name()
+	manifest := &Manifest{
+		ToolVersion: version.Version,
+		Database:    databaseName,
+		CreatedAt:   time.Now().UTC(),
+	}
+	timestamp := manifest.CreatedAt.Local().Format("20060102_150405")
+
+	if opts.Archive {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return "", nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+		path := filepath.Join(outputDir, fmt.Sprintf("dump_%s_%s.archive%s", databaseName, timestamp, compressionExtensions[opts.Compression]))
+		if err := s.writeArchive(path, manifest, collections, opts.Compression); err != nil {
+			return "", nil, err
 		}
+		return path, manifest, nil
+	}
 
-		backupFile, err := s.BackupCollection(collection, outputDir, format)
+	// The mongodump layout nests the collection files in a directory named
+	// after the database, so mongorestore can be pointed at the set
+	dir := filepath.Join(outputDir, fmt.Sprintf("backup_%s_%s", databaseName, timestamp))
+	dataDir := dir
+	if opts.Layout == LayoutMongodump {
+		dir = filepath.Join(outputDir, fmt.Sprintf("dump_%s_%s", databaseName, timestamp))
+		dataDir = filepath.Join(dir, databaseName)
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for _, collection := range collections {
+		file := backupFileName(collection, opts)
+		if opts.Layout == LayoutMongodump {
+			file = dumpFileName(databaseName, collection, opts.Compression)
+		}
+		entry, err := s.backupCollection(collection, dir, file, opts)
 		if err != nil {
-			return backupFiles, fmt.Errorf("failed to backup collection %s: %w", collection, err)
+			return "", nil, fmt.Errorf("failed to backup collection %s: %w", collection, err)
 		}
-		backupFiles = append(backupFiles, backupFile)
+		manifest.Collections = append(manifest.Collections, entry)
 	}
 
-	return backupFiles, nil
+	path, err := manifest.write(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, manifest, nil
 }
 
-func (s *Service) RestoreCollection(collectionName, inputFile, format string, dropExisting bool) error {
-	file, err := os.Open(inputFile)
+// RestoreCollection loads a backup file into a collection. Compressed
+// files are recognised by their magic bytes and decompressed on the fly.
+// The collection options and, when indexes is set, the indexes saved in
+// the metadata sidecar are recreated before any document is inserted.
+func (s *Service) RestoreCollection(collectionName, inputFile, format string, dropExisting, indexes bool) error {
+	reader, err := openBackup(inputFile)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	meta, err := readMetadata(metadataPath(inputFile))
 	if err != nil {
-		return fmt.Errorf("failed to open backup file: %w", err)
+		return err
+	}
+
+	if dropExisting {
+		if err := s.db.DropCollection(collectionName); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	if meta == nil {
+		log.Printf("No metadata found for %s; restoring documents without indexes or collection options", inputFile)
+	} else if err := s.db.ApplyCollectionMetadata(collectionName, meta, indexes); err != nil {
+		return fmt.Errorf("restore failed: %w", err)
 	}
-	defer file.Close()
 
-	if err := s.db.RestoreCollection(collectionName, file, format, dropExisting); err != nil {
+	if err := s.db.RestoreCollection(collectionName, reader, format, false); err != nil {
 		return fmt.Errorf("restore failed: %w", err)
 	}
 
 	return nil
 }
 
-func (s *Service) ValidateBackupFile(filename, expectedFormat string) error {
-	file, err := os.Open(filename)
+// RestoreManifest restores the collections of a backup set, all of them
+// when collections is empty, into the connected database. Every selected
+// file is checked against the manifest before anything is restored.
+func (s *Service) RestoreManifest(manifestPath string, collections []string, dropExisting, indexes bool) error {
+	manifest, dir, err := LoadManifest(manifestPath)
 	if err != nil {
-		return fmt.Errorf("cannot open backup file: %w", err)
+		return err
 	}
-	defer file.Close()
 
-	fileInfo, err := file.Stat()
+	entries, err := manifest.Select(collections)
 	if err != nil {
-		return fmt.Errorf("cannot get file info: %w", err)
+		return err
 	}
 
-	if fileInfo.Size() == 0 {
-		return fmt.Errorf("backup file is empty")
+	for _, entry := range entries {
+		if err := entry.Verify(dir); err != nil {
+			return fmt.Errorf("backup set verification failed: %w", err)
+		}
 	}
 
-	extension := filepath.Ext(filename)
-	if expectedFormat == "json" && extension != ".json" {
-		return fmt.Errorf("expected JSON file but got %s", extension)
+	return s.restoreEntries(dir, entries, dropExisting, indexes)
+}
+
+// restoreEntries restores the collection files of entries, relative to dir
+func (s *Service) restoreEntries(dir string, entries []ManifestEntry, dropExisting, indexes bool) error {
+	for _, entry := range entries {
+		log.Printf("Restoring collection '%s' from %s...", entry.Collection, entry.File)
+		if err := s.RestoreCollection(entry.Collection, filepath.Join(dir, entry.File), entry.Format, dropExisting, indexes); err != nil {
+			return fmt.Errorf("failed to restore collection %s: %w", entry.Collection, err)
+		}
 	}
-	if expectedFormat == "bson" && extension != ".bson" {
-		return fmt.Errorf("expected BSON file but got %s", extension)
+	return nil
+}
+
+// ValidateBackupFile checks that a possibly compressed backup file holds
+// data of the expected format, judging by its decompressed content rather
+// than its name
+func (s *Service) ValidateBackupFile(filename, expectedFormat string) error {
+	reader, err := openBackup(filename)
+	if err != nil {
+		return fmt.Errorf("cannot open backup file: %w", err)
 	}
+	defer reader.Close()
 
+	format, err := sniffFormat(reader)
+	if err != nil {
+		return err
+	}
+	if format != expectedFormat {
+		return fmt.Errorf("expected %s data but the file holds %s", strings.ToUpper(expectedFormat), strings.ToUpper(format))
+	}
 	return nil
-}
\ No newline at end of file
+}
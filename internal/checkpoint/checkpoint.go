@@ -0,0 +1,158 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Checkpoint records how far an import of a given file got. It is stored as
+// <dir>/<sha256>.json so a changed file never picks up a stale checkpoint.
+type Checkpoint struct {
+	File       string `json:"file"`
+	SHA256     string `json:"sha256"`
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	// LastRow is the last input row whose outcome is committed; resuming
+	// starts with the row after it
+	LastRow   int       `json:"last_row"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Settings records how the file was read. Rows are resumed by number,
+	// so they must be read the same way again.
+	Settings Settings `json:"settings"`
+}
+
+// Settings are the import options that decide which row is which and how
+// it is matched
+type Settings struct {
+	// Mapping is the SHA-256 of the mapping file, empty without one
+	Mapping   string `json:"mapping,omitempty"`
+	Key       string `json:"key"`
+	Encoding  string `json:"encoding"`
+	Delimiter string `json:"delimiter,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+	QuoteMode string `json:"quote_mode"`
+	// Sheet is the worksheet read from an .xlsx file
+	Sheet string `json:"sheet,omitempty"`
+	// The Number normalization decides which stored document a row
+	// matches, and the duplicate policy which of several rows wins
+	NumberExpandSci bool   `json:"number_expand_sci,omitempty"`
+	NumberStrip     string `json:"number_strip,omitempty"`
+	NumberCase      string `json:"number_case,omitempty"`
+	NumberPad       int    `json:"number_pad,omitempty"`
+	OnDuplicate     string `json:"on_duplicate"`
+}
+
+// Diff returns the names of the settings that differ between s and other
+func (s Settings) Diff(other Settings) []string {
+	var names []string
+	for _, field := range []struct {
+		name        string
+		mine, other string
+	}{
+		{"mapping", s.Mapping, other.Mapping},
+		{"key", s.Key, other.Key},
+		{"encoding", s.Encoding, other.Encoding},
+		{"delimiter", s.Delimiter, other.Delimiter},
+		{"comment", s.Comment, other.Comment},
+		{"quote-mode", s.QuoteMode, other.QuoteMode},
+		{"sheet", s.Sheet, other.Sheet},
+		{"number-expand-sci", fmt.Sprint(s.NumberExpandSci), fmt.Sprint(other.NumberExpandSci)},
+		{"number-strip", s.NumberStrip, other.NumberStrip},
+		{"number-case", s.NumberCase, other.NumberCase},
+		{"number-pad", fmt.Sprint(s.NumberPad), fmt.Sprint(other.NumberPad)},
+		{"on-duplicate", s.OnDuplicate, other.OnDuplicate},
+	} {
+		if field.mine != field.other {
+			names = append(names, field.name)
+		}
+	}
+	return names
+}
+
+func path(dir, checksum string) string {
+	return filepath.Join(dir, checksum+".json")
+}
+
+// Load reads the checkpoint for the file with the given checksum. It
+// returns nil without an error when there is none.
+func Load(dir, checksum string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path(dir, checksum))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path(dir, checksum), err)
+	}
+	return &cp, nil
+}
+
+// FindByFile returns a checkpoint written for the file at filename under
+// any checksum, or nil when there is none. It is used to tell a changed
+// file apart from one that was never imported.
+func FindByFile(dir, filename string) (*Checkpoint, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		cp, err := Load(dir, strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if cp != nil && cp.File == filename {
+			return cp, nil
+		}
+	}
+	return nil, nil
+}
+
+// Save writes the checkpoint, replacing the previous one in a single rename
+// so a killed import never leaves a partial file behind
+func Save(dir string, cp *Checkpoint) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	cp.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	target := path(dir, cp.SHA256)
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the checkpoint for the file with the given checksum
+func Remove(dir, checksum string) error {
+	if err := os.Remove(path(dir, checksum)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package csv
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Quote handling modes
+const (
+	QuoteStrict = "strict"
+	QuoteLazy   = "lazy"
+)
+
+// delimiterCandidates are tried, in order of preference, when detecting the
+// delimiter from the header line
+var delimiterCandidates = []rune{',', ';', '\t', '|'}
+
+var namedSeparators = map[string]rune{
+	"comma":     ',',
+	"semicolon": ';',
+	"tab":       '\t',
+	`\t`:        '\t',
+	"pipe":      '|',
+}
+
+// WithDelimiter sets the field delimiter instead of detecting it
+func WithDelimiter(delimiter rune) Option {
+	return func(p *Parser) {
+		p.delimiter = delimiter
+	}
+}
+
+// WithComment skips lines starting with the comment character
+func WithComment(comment rune) Option {
+	return func(p *Parser) {
+		p.comment = comment
+	}
+}
+
+// WithQuoteMode selects strict RFC 4180 quoting or lazy quoting, which
+// tolerates stray quotes in unquoted fields
+func WithQuoteMode(mode string) Option {
+	return func(p *Parser) {
+		p.lazyQuotes = mode != QuoteStrict
+	}
+}
+
+// ParseSeparator parses a delimiter or comment flag value: a single
+// character, or one of comma, semicolon, tab (or \t) and pipe
+func ParseSeparator(value string) (rune, error) {
+	if r, ok := namedSeparators[strings.ToLower(value)]; ok {
+		return r, nil
+	}
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("'%s' is not a single character", value)
+	}
+
+	r, _ := utf8.DecodeRuneInString(value)
+	if r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("'%s' cannot be used as a separator", value)
+	}
+	return r, nil
+}
+
+// ValidateQuoteMode reports whether mode can be passed to WithQuoteMode
+func ValidateQuoteMode(mode string) error {
+	if mode != QuoteStrict && mode != QuoteLazy {
+		return fmt.Errorf("invalid quote mode: %s. Use 'strict' or 'lazy'", mode)
+	}
+	return nil
+}
+
+// detectDelimiter picks the candidate that occurs most often outside
+// quotes in the header line, preferring a comma on ties or no match
+func detectDelimiter(reader *bufio.Reader, comment rune) rune {
+	sample, _ := reader.Peek(sniffSize)
+	header := headerLine(string(sample), comment)
+
+	counts := make(map[rune]int)
+	inQuotes := false
+	for _, r := range header {
+		if r == '"' {
+			inQuotes = !inQuotes
+			continue
+		}
+		if !inQuotes {
+			counts[r]++
+		}
+	}
+
+	best := delimiterCandidates[0]
+	for _, candidate := range delimiterCandidates[1:] {
+		if counts[candidate] > counts[best] {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// headerLine returns the first line of sample that is neither blank nor a
+// comment, stopping at a line break outside quotes
+func headerLine(sample string, comment rune) string {
+	for len(sample) > 0 {
+		end := len(sample)
+		inQuotes := false
+		for i, r := range sample {
+			if r == '"' {
+				inQuotes = !inQuotes
+			} else if r == '\n' && !inQuotes {
+				end = i
+				break
+			}
+		}
+
+		line := strings.TrimRight(sample[:end], "\r")
+		isComment := comment != 0 && strings.HasPrefix(line, string(comment))
+		if strings.TrimSpace(line) != "" && !isComment {
+			return line
+		}
+		if end == len(sample) {
+			break
+		}
+		sample = sample[end+1:]
+	}
+	return ""
+}
+
+// describeSeparator renders a separator for log output
+func describeSeparator(r rune) string {
+	switch r {
+	case 0:
+		return "none"
+	case '\t':
+		return `'\t'`
+	}
+	return fmt.Sprintf("'%c'", r)
+}
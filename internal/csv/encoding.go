@@ -0,0 +1,125 @@
+package csv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// EncodingAuto detects the input encoding from its BOM and content
+const EncodingAuto = "auto"
+
+// sniffSize is how much of the file is inspected when detecting the encoding
+const sniffSize = 64 * 1024
+
+// WithEncoding sets the character encoding of the input file. Any WHATWG
+// encoding label is accepted (utf-8, utf-16le, utf-16be, windows-1252,
+// iso-8859-1, ...); "auto" or an empty name detects it.
+func WithEncoding(name string) Option {
+	return func(p *Parser) {
+		p.encoding = name
+	}
+}
+
+// ValidateEncoding reports whether name can be passed to WithEncoding
+func ValidateEncoding(name string) error {
+	if name == "" || strings.EqualFold(name, EncodingAuto) {
+		return nil
+	}
+	if _, err := htmlindex.Get(name); err != nil {
+		return fmt.Errorf("unsupported encoding '%s'", name)
+	}
+	return nil
+}
+
+// decodeReader returns a reader that yields the input as UTF-8. A UTF-8 BOM
+// may still be present and is removed by skipBOM afterwards.
+func (p *Parser) decodeReader(reader *bufio.Reader) (io.Reader, error) {
+	name := p.encoding
+	if name == "" || strings.EqualFold(name, EncodingAuto) {
+		name = p.detectEncoding(reader)
+	}
+
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding '%s'", name)
+	}
+
+	canonical, _ := htmlindex.Name(enc)
+	if canonical == "utf-8" {
+		return reader, nil
+	}
+
+	p.logger.Printf("Converting input from %s to UTF-8", canonical)
+	return transform.NewReader(reader, enc.NewDecoder()), nil
+}
+
+// detectEncoding guesses the encoding from a BOM, from the NUL byte pattern
+// of BOM-less UTF-16, and finally from whether the start of the file is
+// valid UTF-8. Invalid UTF-8 is assumed to be Windows-1252, which is what
+// Excel on Windows writes for "CSV" exports.
+func (p *Parser) detectEncoding(reader *bufio.Reader) string {
+	sample, _ := reader.Peek(sniffSize)
+
+	switch {
+	case len(sample) >= 3 && sample[0] == 0xEF && sample[1] == 0xBB && sample[2] == 0xBF:
+		return "utf-8"
+	case len(sample) >= 2 && sample[0] == 0xFF && sample[1] == 0xFE:
+		p.logger.Println("UTF-16LE BOM detected")
+		return "utf-16le"
+	case len(sample) >= 2 && sample[0] == 0xFE && sample[1] == 0xFF:
+		p.logger.Println("UTF-16BE BOM detected")
+		return "utf-16be"
+	}
+
+	if guess := guessUTF16(sample); guess != "" {
+		p.logger.Printf("Input looks like %s without a BOM", guess)
+		return guess
+	}
+
+	// A full sample may end in the middle of a multi-byte character
+	if len(sample) == sniffSize {
+		for i := 0; i < utf8.UTFMax-1 && !utf8.Valid(sample); i++ {
+			sample = sample[:len(sample)-1]
+		}
+	}
+
+	if utf8.Valid(sample) {
+		return "utf-8"
+	}
+
+	p.logger.Println("WARNING: Input is not valid UTF-8, assuming windows-1252 (use --encoding to override)")
+	return "windows-1252"
+}
+
+// guessUTF16 recognises BOM-less UTF-16 text, where mostly-ASCII content
+// leaves a NUL in every other byte
+func guessUTF16(sample []byte) string {
+	pairs := len(sample) / 2
+	if pairs < 2 {
+		return ""
+	}
+
+	evenZeros, oddZeros := 0, 0
+	for i := 0; i+1 < len(sample); i += 2 {
+		if sample[i] == 0 {
+			evenZeros++
+		}
+		if sample[i+1] == 0 {
+			oddZeros++
+		}
+	}
+
+	switch {
+	case oddZeros*10 >= pairs*3 && evenZeros*10 < pairs:
+		return "utf-16le"
+	case evenZeros*10 >= pairs*3 && oddZeros*10 < pairs:
+		return "utf-16be"
+	}
+	return ""
+}
@@ -0,0 +1,182 @@
+package csv
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"excelDisclaimer/internal/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Supported column transforms
+const (
+	TransformNone  = "none"
+	TransformTrim  = "trim"
+	TransformUpper = "upper"
+	TransformLower = "lower"
+)
+
+// Transforms is a list of transform names applied in order. In YAML it may
+// be written either as a single name or as a list of names.
+type Transforms []string
+
+func (t *Transforms) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = Transforms{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// FieldMapping describes how a single target BSON field is read from the CSV
+type FieldMapping struct {
+	Aliases   []string   `yaml:"aliases"`
+	Required  bool       `yaml:"required"`
+	Default   string     `yaml:"default"`
+	Transform Transforms `yaml:"transform"`
+}
+
+// Mapping maps target BSON fields to the CSV columns they are read from
+type Mapping struct {
+	Fields map[string]FieldMapping `yaml:"fields"`
+	// Key optionally lists the fields used to match stored documents
+	Key models.MatchKey `yaml:"key"`
+}
+
+// DefaultMapping reproduces the built-in column layout: product, number,
+// description and verbal disclaimer, plus an autoselect column, all
+// optional and trimmed
+func DefaultMapping() *Mapping {
+	return &Mapping{
+		Fields: map[string]FieldMapping{
+			"Product":            {Aliases: []string{"product"}},
+			"Number":             {Aliases: []string{"number"}},
+			"Description":        {Aliases: []string{"description"}},
+			"DisclaimerVerbiage": {Aliases: []string{"verbal disclaimer"}},
+			"AutoSelect":         {Aliases: []string{"autoselect", "auto select"}},
+		},
+	}
+}
+
+// LoadMapping reads and validates a YAML column mapping file
+func LoadMapping(filename string) (*Mapping, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mapping file: %w", err)
+	}
+
+	var mapping Mapping
+	if err := yaml.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to parse mapping file: %w", err)
+	}
+
+	if err := mapping.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mapping file %s: %w", filename, err)
+	}
+	return &mapping, nil
+}
+
+// Validate checks that every target field exists on ProductRecord, that
+// every alias and transform is usable and that the fields of the mapping's
+// own key are mapped. The key actually used by an import may come from
+// --key instead, so the default key is checked by the caller.
+func (m *Mapping) Validate() error {
+	if len(m.Fields) == 0 {
+		return fmt.Errorf("no fields defined")
+	}
+	if len(m.Key) > 0 {
+		if err := m.Key.Validate(); err != nil {
+			return err
+		}
+		if err := m.CheckKey(m.Key); err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[string]string)
+	for field, fm := range m.Fields {
+		if !models.IsMappableField(field) {
+			return fmt.Errorf("unknown target field %q", field)
+		}
+		if len(fm.Aliases) == 0 {
+			return fmt.Errorf("field %s has no aliases", field)
+		}
+		for _, alias := range fm.Aliases {
+			normalized := normalizeHeader(alias)
+			if normalized == "" {
+				return fmt.Errorf("field %s has an empty alias", field)
+			}
+			if other, exists := seen[normalized]; exists && other != field {
+				return fmt.Errorf("alias %q is used by both %s and %s", alias, other, field)
+			}
+			seen[normalized] = field
+		}
+		for _, transform := range fm.Transform {
+			switch transform {
+			case TransformNone, TransformTrim, TransformUpper, TransformLower:
+			default:
+				return fmt.Errorf("field %s has unknown transform %q", field, transform)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckKey reports an error when a field of key is not mapped. Rows read
+// without it have an empty key and would all be skipped by validation.
+func (m *Mapping) CheckKey(key models.MatchKey) error {
+	for _, field := range key {
+		if _, exists := m.Fields[field]; !exists {
+			return fmt.Errorf("match key field %s is not mapped to any column", field)
+		}
+	}
+	return nil
+}
+
+// orderedFields returns the mapped target fields in ProductRecord order
+func (m *Mapping) orderedFields() []string {
+	var fields []string
+	for _, field := range models.MappableFields {
+		if _, exists := m.Fields[field]; exists {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// apply runs the configured transforms over a raw cell value. Values are
+// trimmed when no transform is configured; "none" keeps them verbatim.
+func (fm FieldMapping) apply(value string) string {
+	transforms := fm.Transform
+	if len(transforms) == 0 {
+		transforms = Transforms{TransformTrim}
+	}
+
+	for _, transform := range transforms {
+		switch transform {
+		case TransformTrim:
+			value = strings.TrimSpace(value)
+		case TransformUpper:
+			value = strings.ToUpper(value)
+		case TransformLower:
+			value = strings.ToLower(value)
+		}
+	}
+
+	if value == "" {
+		value = fm.Default
+	}
+	return value
+}
+
+// normalizeHeader makes header matching case- and whitespace-insensitive
+func normalizeHeader(header string) string {
+	return strings.TrimSpace(strings.ToLower(header))
+}
@@ -1,6 +1,7 @@
 package csv
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/csv"
 	"fmt"
@@ -10,144 +11,375 @@ import (
 	"strings"
 
 	"excelDisclaimer/internal/models"
+	"excelDisclaimer/internal/normalize"
 
 	"github.com/jszwec/csvutil"
 )
 
 type Parser struct {
 	filename string
+	mapping  *Mapping
+
+	keepExtra  bool
+	extraField string
+
+	encoding   string
+	delimiter  rune
+	comment    rune
+	lazyQuotes bool
+	number     normalize.Number
+
+	headers []string
+	dialect string
+	logger  *log.Logger
 }
 
-func NewParser(filename string) *Parser {
-	return &Parser{filename: filename}
+// Option configures optional Parser behaviour
+type Option func(*Parser)
+
+// WithMapping replaces the built-in column layout with a custom mapping
+func WithMapping(mapping *Mapping) Option {
+	return func(p *Parser) {
+		p.mapping = mapping
+	}
 }
 
-func (p *Parser) ParseRecords() ([]models.ProductRecord, error) {
-	// Read entire file to handle BOM
-	data, err := os.ReadFile(p.filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read CSV file: %w", err)
+// WithExtraColumns keeps columns that are not mapped to a record field.
+// They are stored as top-level document fields, or under the embedded
+// document named subdocument when it is not empty.
+func WithExtraColumns(subdocument string) Option {
+	return func(p *Parser) {
+		p.keepExtra = true
+		p.extraField = subdocument
 	}
+}
 
-	// Remove BOM if present
-	data = removeBOM(data)
+// WithNumberNormalizer normalizes the Number of every record so that it can
+// be used as a stable match key
+func WithNumberNormalizer(number normalize.Number) Option {
+	return func(p *Parser) {
+		p.number = number
+	}
+}
+
+// WithQuiet suppresses the parser's progress and header log output
+func WithQuiet() Option {
+	return func(p *Parser) {
+		p.logger = log.New(io.Discard, "", 0)
+	}
+}
 
-	// Create reader from cleaned data
-	reader := bytes.NewReader(data)
+func NewParser(filename string, opts ...Option) *Parser {
+	p := &Parser{
+		filename:   filename,
+		mapping:    DefaultMapping(),
+		lazyQuotes: true,
+		logger:     log.Default(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Row is a single data row read from the input file
+type Row struct {
+	// RowNumber is the 1-based row in the file; the header is row 1
+	RowNumber int
+	// Values are the original cell values of the row
+	Values []string
+	Record models.ProductRecord
+}
+
+// rowReader yields raw rows one at a time, header first
+type rowReader interface {
+	Read() ([]string, error)
+}
 
-	// Parse using case-insensitive approach
-	records, err := p.parseFlexible(reader)
+// rowNumberer is implemented by readers that know the position of the last
+// row read in the source file, such as worksheets with blank rows
+type rowNumberer interface {
+	RowNumber() int
+}
+
+// columnLayout is the result of matching the header row against the mapping
+type columnLayout struct {
+	fields  []string
+	columns map[string]int
+	extra   map[int]string
+}
+
+// ParseRecords reads the whole file into memory. Use StreamRecords for
+// large files.
+func (p *Parser) ParseRecords() ([]models.ProductRecord, error) {
+	var records []models.ProductRecord
+	err := p.StreamRecords(func(row Row) error {
+		records = append(records, row.Record)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return records, nil
+}
 
-	// Set default AutoSelect value for all records
-	for i := range records {
-		records[i].AutoSelect = ""
+// StreamRecords parses the file one row at a time and calls fn for every
+// data row, so memory use does not grow with the size of the file.
+// Parsing stops at the first error returned by fn.
+func (p *Parser) StreamRecords(fn func(Row) error) error {
+	file, err := os.Open(p.filename)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV file: %w", err)
 	}
+	defer file.Close()
 
-	return records, nil
-}
+	decoded, err := p.decodeReader(bufio.NewReaderSize(file, sniffSize))
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(decoded, sniffSize)
 
-// removeBOM removes the UTF-8 BOM if present
-func removeBOM(data []byte) []byte {
-	// UTF-8 BOM is 0xEF, 0xBB, 0xBF
-	if len(data) >= 3 && data[0] == 0xEF && data[1] == 0xBB && data[2] == 0xBF {
-		log.Println("BOM detected and removed from CSV file")
-		return data[3:]
+	// Remove BOM if present
+	if err := p.skipBOM(reader); err != nil {
+		return fmt.Errorf("failed to read CSV file: %w", err)
 	}
-	return data
-}
 
-func (p *Parser) parseFlexible(reader io.Reader) ([]models.ProductRecord, error) {
+	delimiter, source := p.delimiter, "given"
+	if delimiter == 0 {
+		delimiter, source = detectDelimiter(reader, p.comment), "detected"
+	}
+
+	quoteMode := QuoteStrict
+	if p.lazyQuotes {
+		quoteMode = QuoteLazy
+	}
+	p.dialect = fmt.Sprintf("delimiter %s (%s), comment %s, quotes %s",
+		describeSeparator(delimiter), source, describeSeparator(p.comment), quoteMode)
+
 	csvReader := csv.NewReader(reader)
+	csvReader.Comma = delimiter
+	csvReader.Comment = p.comment
 	csvReader.FieldsPerRecord = -1
 	csvReader.TrimLeadingSpace = true
-	csvReader.LazyQuotes = true  // Allow quotes in unquoted fields for messy CSVs
+	csvReader.LazyQuotes = p.lazyQuotes // Allow quotes in unquoted fields for messy CSVs
+
+	return p.streamRows(csvReader, fn)
+}
 
-	headers, err := csvReader.Read()
+// Headers returns the header row of the most recently parsed file
+func (p *Parser) Headers() []string {
+	return p.headers
+}
+
+// skipBOM discards the UTF-8 BOM if present
+func (p *Parser) skipBOM(reader *bufio.Reader) error {
+	// UTF-8 BOM is 0xEF, 0xBB, 0xBF
+	prefix, err := reader.Peek(3)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if bytes.Equal(prefix, []byte{0xEF, 0xBB, 0xBF}) {
+		p.logger.Println("BOM detected and removed from CSV file")
+		_, err = reader.Discard(3)
+		return err
+	}
+	return nil
+}
+
+func (p *Parser) streamRows(reader rowReader, fn func(Row) error) error {
+	headers, err := reader.Read()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read CSV headers: %w", err)
+		return fmt.Errorf("failed to read CSV headers: %w", err)
 	}
+	p.headers = headers
 
-	log.Printf("CSV Headers found: %v", headers)
-	log.Printf("Expected headers (case-insensitive): [product, number, description, verbal disclaimer]")
+	layout, err := p.resolveColumns(headers)
+	if err != nil {
+		return err
+	}
+
+	rowNum := 1
+	count := 0
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV row %d: %w", rowNum+1, err)
+		}
+		rowNum++
+		if numberer, ok := reader.(rowNumberer); ok {
+			rowNum = numberer.RowNumber()
+		}
 
-	// Only process first 4 columns, ignore any extras
-	maxColumns := 4
-	if len(headers) > maxColumns {
-		log.Printf("WARNING: CSV has %d columns, but only processing first %d", len(headers), maxColumns)
-		headers = headers[:maxColumns]
+		row := Row{
+			RowNumber: rowNum,
+			Values:    values,
+			Record:    p.buildRecord(layout, values),
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+		count++
+	}
+
+	p.logger.Printf("Parsed %d records from CSV", count)
+	return nil
+}
+
+// resolveColumns matches the header row against the mapping and logs how
+// every column will be used
+func (p *Parser) resolveColumns(headers []string) (*columnLayout, error) {
+	p.logger.Printf("CSV Headers found: %v", headers)
+	if p.dialect != "" {
+		p.logger.Printf("CSV dialect: %s", p.dialect)
 	}
 
 	// Create a map of lowercase headers to their indices
 	headerMap := make(map[string]int)
 	for i, header := range headers {
-		normalized := strings.TrimSpace(strings.ToLower(header))
-		headerMap[normalized] = i
-		log.Printf("  Column %d: '%s' (normalized: '%s')", i, header, normalized)
+		normalized := normalizeHeader(header)
+		if _, exists := headerMap[normalized]; !exists {
+			headerMap[normalized] = i
+		}
+		p.logger.Printf("  Column %d: '%s' (normalized: '%s')", i, header, normalized)
 	}
 
-	// Check for required columns
-	requiredColumns := map[string]string{
-		"product":           "Product",
-		"number":            "Number",
-		"description":       "Description",
-		"verbal disclaimer": "DisclaimerVerbiage",
+	layout := &columnLayout{
+		fields:  p.mapping.orderedFields(),
+		columns: make(map[string]int),
+		extra:   make(map[int]string),
 	}
 
+	// Resolve each target field to the first of its aliases present in the file
+	mappedColumns := make(map[int]bool)
 	missingColumns := []string{}
-	for csvName, fieldName := range requiredColumns {
-		if _, exists := headerMap[csvName]; !exists {
-			missingColumns = append(missingColumns, fmt.Sprintf("%s (for field %s)", csvName, fieldName))
+	missingRequired := []string{}
+	for _, field := range layout.fields {
+		fm := p.mapping.Fields[field]
+		p.logger.Printf("Expected headers for %s (case-insensitive): %v", field, fm.Aliases)
+
+		found := false
+		for _, alias := range fm.Aliases {
+			if idx, exists := headerMap[normalizeHeader(alias)]; exists {
+				layout.columns[field] = idx
+				mappedColumns[idx] = true
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
 		}
+
+		description := fmt.Sprintf("%s (for field %s)", strings.Join(fm.Aliases, " / "), field)
+		if fm.Required {
+			missingRequired = append(missingRequired, description)
+		} else if field == "AutoSelect" {
+			// AutoSelect is usually computed by rules, so a file without the
+			// column is expected
+			continue
+		} else {
+			missingColumns = append(missingColumns, description)
+		}
+	}
+
+	if len(missingRequired) > 0 {
+		return nil, fmt.Errorf("missing required columns: %v", missingRequired)
 	}
 
 	if len(missingColumns) > 0 {
-		log.Printf("WARNING: Missing expected columns: %v", missingColumns)
-		log.Printf("This may result in empty fields in the imported data")
+		p.logger.Printf("WARNING: Missing expected columns: %v", missingColumns)
+		p.logger.Printf("This may result in empty fields in the imported data")
 	}
 
-	var records []models.ProductRecord
-	rowNum := 1
+	if p.keepExtra && p.extraField != "" && isReservedField(p.extraField) {
+		return nil, fmt.Errorf("extra column sub-document '%s' clashes with a record field", p.extraField)
+	}
 
-	for {
-		row, err := csvReader.Read()
-		if err == io.EOF {
-			break
+	usedPaths := make(map[string]bool)
+	for i, header := range headers {
+		if mappedColumns[i] {
+			continue
 		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read CSV row %d: %w", rowNum+1, err)
+		if !p.keepExtra {
+			p.logger.Printf("WARNING: Column %d ('%s') is not mapped to any field and will be ignored", i, header)
+			continue
 		}
-		rowNum++
 
-		// Limit row to first 4 columns if it has more
-		if len(row) > maxColumns {
-			row = row[:maxColumns]
+		path, err := p.extraPath(i, header)
+		if err != nil {
+			p.logger.Printf("WARNING: Column %d ('%s') will be ignored: %v", i, header, err)
+			continue
 		}
+		if usedPaths[path] {
+			p.logger.Printf("WARNING: Column %d ('%s') will be ignored: duplicate field '%s'", i, header, path)
+			continue
+		}
+		usedPaths[path] = true
+		layout.extra[i] = path
+		p.logger.Printf("  Column %d ('%s') will be kept as field '%s'", i, header, path)
+	}
 
-		record := models.ProductRecord{}
+	return layout, nil
+}
 
-		// Map columns flexibly
-		if idx, exists := headerMap["product"]; exists && idx < len(row) {
-			record.Product = strings.TrimSpace(row[idx])
-		}
-		if idx, exists := headerMap["number"]; exists && idx < len(row) {
-			record.Number = strings.TrimSpace(row[idx])
-		}
-		if idx, exists := headerMap["description"]; exists && idx < len(row) {
-			record.Description = strings.TrimSpace(row[idx])
+// buildRecord maps a raw row onto a ProductRecord
+func (p *Parser) buildRecord(layout *columnLayout, row []string) models.ProductRecord {
+	record := models.ProductRecord{}
+
+	// Map columns flexibly; quoted commas in the disclaimer are already
+	// handled by the CSV reader
+	for _, field := range layout.fields {
+		value := ""
+		if idx, exists := layout.columns[field]; exists && idx < len(row) {
+			value = row[idx]
 		}
-		if idx, exists := headerMap["verbal disclaimer"]; exists && idx < len(row) {
-			// The disclaimer may contain commas, quotes will be handled by CSV reader
-			record.DisclaimerVerbiage = strings.TrimSpace(row[idx])
+		record.SetField(field, p.mapping.Fields[field].apply(value))
+	}
+
+	if p.number.Enabled() {
+		record.Number = p.number.Apply(record.Number)
+	}
+
+	if len(layout.extra) > 0 {
+		record.Extra = make(map[string]string, len(layout.extra))
+		for idx, path := range layout.extra {
+			value := ""
+			if idx < len(row) {
+				value = strings.TrimSpace(row[idx])
+			}
+			record.Extra[path] = value
 		}
+	}
 
-		records = append(records, record)
+	return record
+}
+
+// extraPath turns an unmapped header into the document path it is stored
+// under. Dots and leading dollar signs are not allowed in field names.
+func (p *Parser) extraPath(index int, header string) (string, error) {
+	name := strings.TrimSpace(header)
+	name = strings.ReplaceAll(name, ".", "_")
+	name = strings.TrimLeft(name, "$")
+	if name == "" {
+		name = fmt.Sprintf("Column%d", index+1)
 	}
 
-	log.Printf("Parsed %d records from CSV", len(records))
-	return records, nil
+	if p.extraField != "" {
+		return p.extraField + "." + name, nil
+	}
+	if isReservedField(name) {
+		return "", fmt.Errorf("field name '%s' is reserved", name)
+	}
+	return name, nil
+}
+
+func isReservedField(name string) bool {
+	return name == "_id" || name == "AutoSelect" || name == "Archived" || name == "ArchivedAt" ||
+		models.IsMappableField(name) ||
+		strings.ContainsAny(name, ".$")
 }
 
 // Legacy method for backward compatibility
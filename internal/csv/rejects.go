@@ -0,0 +1,134 @@
+package csv
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Reasons a row ends up in the rejects file
+const (
+	RejectSkipped   = "skipped"
+	RejectDuplicate = "duplicate"
+	RejectFailed    = "failed"
+)
+
+// RejectWriter writes rejected rows back out as CSV with their original
+// columns followed by row_number, reason and error, so they can be fixed in
+// Excel and re-imported on their own
+type RejectWriter struct {
+	file    *os.File
+	writer  *csv.Writer
+	columns int
+	count   int
+	// header is set once the header line is in the file
+	header bool
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+func NewRejectWriter(filename string) (*RejectWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rejects file: %w", err)
+	}
+
+	// A UTF-8 BOM makes Excel open the file with the right encoding
+	if _, err := file.Write(utf8BOM); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write rejects file: %w", err)
+	}
+
+	return &RejectWriter{file: file, writer: csv.NewWriter(file)}, nil
+}
+
+// ResumeRejectWriter reopens the rejects file of an interrupted import. Rows
+// up to afterRow were rejected before the checkpoint and are kept; later
+// ones are dropped, as the resumed import reads those rows again.
+func ResumeRejectWriter(filename string, afterRow int) (*RejectWriter, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewRejectWriter(filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rejects file: %w", err)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, utf8BOM))).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rejects file %s: %w", filename, err)
+	}
+
+	w, err := NewRejectWriter(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return w, nil
+	}
+
+	// row_number is the third column from the end
+	header := records[0]
+	if len(header) < 3 {
+		w.Close()
+		return nil, fmt.Errorf("rejects file %s has no row_number column", filename)
+	}
+	w.columns = len(header) - 3
+	w.header = true
+	if err := w.writer.Write(header); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to write rejects header: %w", err)
+	}
+	for _, record := range records[1:] {
+		row, err := strconv.Atoi(record[len(record)-3])
+		if err != nil || row > afterRow {
+			continue
+		}
+		if err := w.writer.Write(record); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("failed to write rejects file: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// Write appends a rejected row. The header line is written before the
+// first row; cells beyond the header are dropped so the appended columns
+// stay aligned.
+func (w *RejectWriter) Write(headers []string, row Row, reason, message string) error {
+	if !w.header {
+		w.columns = len(headers)
+		header := append(append([]string{}, headers...), "row_number", "reason", "error")
+		if err := w.writer.Write(header); err != nil {
+			return fmt.Errorf("failed to write rejects header: %w", err)
+		}
+		w.header = true
+	}
+
+	record := make([]string, w.columns, w.columns+3)
+	copy(record, row.Values)
+	record = append(record, strconv.Itoa(row.RowNumber), reason, message)
+	if err := w.writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write rejected row %d: %w", row.RowNumber, err)
+	}
+
+	w.count++
+	return nil
+}
+
+// Count returns the number of rows written by this import
+func (w *RejectWriter) Count() int {
+	return w.count
+}
+
+func (w *RejectWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to write rejects file: %w", err)
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,99 @@
+package csv
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// sheetReader adapts an excelize row iterator to rowReader. Blank rows are
+// skipped, and RowNumber reports the worksheet row of the last row read so
+// row numbers match what users see in Excel.
+type sheetReader struct {
+	rows   *excelize.Rows
+	rowNum int
+}
+
+func (r *sheetReader) Read() ([]string, error) {
+	for r.rows.Next() {
+		r.rowNum++
+		values, err := r.rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+		if !isBlankRow(values) {
+			return values, nil
+		}
+	}
+	if err := r.rows.Error(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (r *sheetReader) RowNumber() int {
+	return r.rowNum
+}
+
+func isBlankRow(values []string) bool {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// StreamSheet reads a worksheet of an .xlsx workbook the same way
+// StreamRecords reads a CSV file. The sheet is selected by name, or by
+// 1-based position when no sheet has that name; an empty sheet selects the
+// first one. The first non-blank row is used as the header.
+func (p *Parser) StreamSheet(sheet string, fn func(Row) error) error {
+	workbook, err := excelize.OpenFile(p.filename)
+	if err != nil {
+		return fmt.Errorf("failed to open workbook: %w", err)
+	}
+	defer workbook.Close()
+
+	name, err := resolveSheet(workbook, sheet)
+	if err != nil {
+		return err
+	}
+
+	rows, err := workbook.Rows(name)
+	if err != nil {
+		return fmt.Errorf("failed to read sheet '%s': %w", name, err)
+	}
+	defer rows.Close()
+
+	return p.streamRows(&sheetReader{rows: rows}, fn)
+}
+
+func resolveSheet(workbook *excelize.File, sheet string) (string, error) {
+	sheets := workbook.GetSheetList()
+	if len(sheets) == 0 {
+		return "", fmt.Errorf("workbook has no sheets")
+	}
+	if sheet == "" {
+		return sheets[0], nil
+	}
+
+	// Sheet names are matched case-insensitively, like Excel does
+	for _, name := range sheets {
+		if strings.EqualFold(name, sheet) {
+			return name, nil
+		}
+	}
+
+	if index, err := strconv.Atoi(sheet); err == nil {
+		if index < 1 || index > len(sheets) {
+			return "", fmt.Errorf("sheet index %d out of range (workbook has %d sheets)", index, len(sheets))
+		}
+		return sheets[index-1], nil
+	}
+
+	return "", fmt.Errorf("sheet '%s' not found (available: %s)", sheet, strings.Join(sheets, ", "))
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"excelDisclaimer/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Collections holding the audit trail of imports
+const (
+	ImportRunsCollection    = "import_runs"
+	RecordHistoryCollection = "record_history"
+)
+
+// StartImportRun records the start of an import and sets run.ID. Runs are
+// written outside any open transaction so a rolled back import still
+// leaves its audit record.
+func (m *MongoDB) StartImportRun(run *models.ImportRun) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := m.Database.Collection(ImportRunsCollection).InsertOne(ctx, run)
+	if err != nil {
+		return fmt.Errorf("failed to record import run: %w", err)
+	}
+	run.ID = res.InsertedID
+	return nil
+}
+
+// FinishImportRun stores the outcome of a run started by StartImportRun
+func (m *MongoDB) FinishImportRun(run *models.ImportRun) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"FinishedAt": run.FinishedAt,
+		"Status":     run.Status,
+		"Error":      run.Error,
+		"Counts":     run.Counts,
+	}}
+	if _, err := m.Database.Collection(ImportRunsCollection).UpdateByID(ctx, run.ID, update); err != nil {
+		return fmt.Errorf("failed to update import run: %w", err)
+	}
+	return nil
+}
+
+// InsertRecordHistory writes change log entries, joining the open
+// transaction if any so they roll back with the changes they describe
+func (m *MongoDB) InsertRecordHistory(entries []models.RecordHistory) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ctx, cancel := m.context(2 * time.Minute)
+	defer cancel()
+
+	documents := make([]interface{}, len(entries))
+	for i := range entries {
+		documents[i] = entries[i]
+	}
+	if _, err := m.Database.Collection(RecordHistoryCollection).InsertMany(ctx, documents); err != nil {
+		return fmt.Errorf("failed to write record history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CollectionMetadata holds what a document dump does not capture: the
+// collection options reported by listCollections (validator, collation,
+// capped size, ...) and the index specs reported by listIndexes. The name
+// and type are stored as mongodump does, so either tool can read the file.
+type CollectionMetadata struct {
+	CollectionName string   `bson:"collectionName,omitempty"`
+	Type           string   `bson:"type,omitempty"`
+	Options        bson.D   `bson:"options"`
+	Indexes        []bson.D `bson:"indexes"`
+}
+
+// CollectionMetadata reads the options and indexes of a collection
+func (m *MongoDB) CollectionMetadata(collectionName string) (*CollectionMetadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	meta := &CollectionMetadata{CollectionName: collectionName, Type: "collection", Options: bson.D{}, Indexes: []bson.D{}}
+
+	specs, err := m.Database.ListCollectionSpecifications(ctx, bson.M{"name": collectionName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read options of %s: %w", collectionName, err)
+	}
+	if len(specs) > 0 && specs[0].Type != "" {
+		meta.Type = specs[0].Type
+	}
+	if len(specs) > 0 && specs[0].Options != nil {
+		if err := bson.Unmarshal(specs[0].Options, &meta.Options); err != nil {
+			return nil, fmt.Errorf("failed to decode options of %s: %w", collectionName, err)
+		}
+	}
+
+	cursor, err := m.Database.Collection(collectionName).Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexes of %s: %w", collectionName, err)
+	}
+	if err := cursor.All(ctx, &meta.Indexes); err != nil {
+		return nil, fmt.Errorf("failed to read indexes of %s: %w", collectionName, err)
+	}
+	return meta, nil
+}
+
+func (m *MongoDB) DropCollection(collectionName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := m.Database.Collection(collectionName).Drop(ctx); err != nil {
+		return fmt.Errorf("failed to drop collection %s: %w", collectionName, err)
+	}
+	return nil
+}
+
+// ApplyCollectionMetadata recreates a collection with its backed up options
+// and, when indexes is set, its indexes. Options can only be set when the
+// collection is created, so they are skipped with a warning when it
+// already exists; indexes that already exist are left as they are.
+func (m *MongoDB) ApplyCollectionMetadata(collectionName string, meta *CollectionMetadata, indexes bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	existing, err := m.Database.ListCollectionNames(ctx, bson.M{"name": collectionName})
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %w", err)
+	}
+
+	if len(meta.Options) > 0 {
+		if len(existing) > 0 {
+			log.Printf("Warning: collection '%s' already exists, its backed up options are not applied (use --drop)", collectionName)
+		} else {
+			create := append(bson.D{{Key: "create", Value: collectionName}}, meta.Options...)
+			if err := m.Database.RunCommand(ctx, create).Err(); err != nil {
+				return fmt.Errorf("failed to create collection %s with its options: %w", collectionName, err)
+			}
+			log.Printf("Created collection '%s' with %d backed up options", collectionName, len(meta.Options))
+		}
+	}
+
+	if !indexes {
+		return nil
+	}
+
+	// The _id index always exists; the index version and namespace are
+	// chosen by the server
+	var specs []interface{}
+	for _, index := range meta.Indexes {
+		spec := bson.D{}
+		name := ""
+		for _, elem := range index {
+			switch elem.Key {
+			case "v", "ns":
+				continue
+			case "name":
+				name, _ = elem.Value.(string)
+			}
+			spec = append(spec, elem)
+		}
+		if name != "_id_" {
+			specs = append(specs, spec)
+		}
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+
+	command := bson.D{{Key: "createIndexes", Value: collectionName}, {Key: "indexes", Value: specs}}
+	if err := m.Database.RunCommand(ctx, command).Err(); err != nil {
+		return fmt.Errorf("failed to create indexes on %s: %w", collectionName, err)
+	}
+	log.Printf("Created %d indexes on collection '%s'", len(specs), collectionName)
+	return nil
+}
+
@@ -3,9 +3,11 @@ package database
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"reflect"
 	"time"
 
 	"excelDisclaimer/internal/models"
@@ -18,6 +20,9 @@ import (
 type MongoDB struct {
 	Client   *mongo.Client
 	Database *mongo.Database
+
+	// session holds the open transaction started by BeginTransaction
+	session mongo.Session
 }
 
 func NewMongoDB(uri, dbName string) (*MongoDB, error) {
@@ -59,92 +64,394 @@ func (m *MongoDB) InsertRecord(collectionName string, record interface{}) error
 	return nil
 }
 
-// UpsertRecord inserts or updates a record based on Number field
-// Preserves existing fields that are not part of the core CSV import
-func (m *MongoDB) UpsertRecord(collectionName string, record models.ProductRecord) (bool, error) {
+// coreFields are the ProductRecord fields owned by the CSV import.
+// Every other field on a stored document is left untouched on update.
+var coreFields = []string{"Product", "Number", "Description", "DisclaimerVerbiage"}
+
+// BulkResult summarises the outcome of a single BulkUpsertRecords call
+type BulkResult struct {
+	Inserted int
+	Updated  int
+	Failed   int
+	// Errors maps the index of a record in the submitted batch to the
+	// reason it was not written
+	Errors map[int]error
+	// UpsertedIDs maps the index of each inserted record to its new _id
+	UpsertedIDs map[int]interface{}
+}
+
+// BulkUpsertRecords upserts a batch of records with a single BulkWrite.
+// Callers are expected to leave out records that would not change anything.
+// Records are matched on _id when ID is set and on key otherwise; only the
+// core CSV fields and the record's own extra columns are overwritten on
+// existing documents so every other field is preserved. AutoSelect is set
+// when a new document is inserted and on a matched document only when the
+// record carries a value, so callers clear it to keep the stored one.
+// Archived documents that are written are restored.
+func (m *MongoDB) BulkUpsertRecords(collectionName string, key models.MatchKey, records []models.ProductRecord, ordered bool) (*BulkResult, error) {
+	result := &BulkResult{Errors: make(map[int]error), UpsertedIDs: make(map[int]interface{})}
+	if len(records) == 0 {
+		return result, nil
+	}
+
 	collection := m.Database.Collection(collectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := m.context(2*time.Minute)
 	defer cancel()
 
-	// Use Number field as unique identifier
-	filter := bson.M{"Number": record.Number}
-	
-	// First, check if record exists to preserve extra fields
-	var existingDoc bson.M
-	err := collection.FindOne(ctx, filter).Decode(&existingDoc)
-	
-	wasUpdate := false
-	var finalDoc bson.M
-	
-	if err == nil {
-		// Record exists - preserve extra fields
-		wasUpdate = true
-		
-		// Convert new record to bson.M for merging
-		newDocBytes, err := bson.Marshal(record)
-		if err != nil {
-			return false, fmt.Errorf("failed to marshal new record: %w", err)
-		}
-		
-		var newDoc bson.M
-		if err := bson.Unmarshal(newDocBytes, &newDoc); err != nil {
-			return false, fmt.Errorf("failed to unmarshal new record: %w", err)
-		}
-		
-		// Start with existing document to preserve all extra fields
-		finalDoc = existingDoc
-		
-		// Update core fields from CSV import (only the 4 CSV fields)
-		coreFields := []string{"Product", "Number", "Description", "DisclaimerVerbiage"}
-		for _, field := range coreFields {
-			if value, exists := newDoc[field]; exists {
-				finalDoc[field] = value
+	writeModels := make([]mongo.WriteModel, 0, len(records))
+	for _, record := range records {
+		set := bson.M{
+			"Product":            record.Product,
+			"Number":             record.Number,
+			"Description":        record.Description,
+			"DisclaimerVerbiage": record.DisclaimerVerbiage,
+		}
+		for path, value := range record.Extra {
+			set[path] = value
+		}
+		update := bson.M{"$set": set}
+		if record.ID != nil && record.AutoSelect != "" {
+			set["AutoSelect"] = record.AutoSelect
+		} else {
+			update["$setOnInsert"] = bson.M{"AutoSelect": record.AutoSelect}
+		}
+		if !record.Archived {
+			update["$unset"] = bson.M{"Archived": "", "ArchivedAt": ""}
+		}
+
+		// Records already matched to a stored document are updated by _id,
+		// which also covers documents whose stored key is not normalized
+		filter := keyFilter(key, record)
+		if record.ID != nil {
+			filter = bson.M{"_id": record.ID}
+		}
+		writeModels = append(writeModels, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(update).
+			SetUpsert(true))
+	}
+
+	opts := options.BulkWrite().SetOrdered(ordered)
+	res, err := collection.BulkWrite(ctx, writeModels, opts)
+	if res != nil {
+		result.Inserted = int(res.UpsertedCount)
+		result.Updated = int(res.MatchedCount)
+		for idx, id := range res.UpsertedIDs {
+			result.UpsertedIDs[int(idx)] = id
+		}
+	}
+
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) == 0 {
+			return nil, fmt.Errorf("bulk write failed: %w", err)
+		}
+
+		for _, writeErr := range bulkErr.WriteErrors {
+			result.Errors[writeErr.Index] = errors.New(writeErr.Message)
+		}
+
+		// An ordered bulk write stops at the first error, so every later
+		// operation in the batch was never sent
+		if ordered {
+			first := bulkErr.WriteErrors[0].Index
+			for i := first + 1; i < len(records); i++ {
+				result.Errors[i] = errors.New("not executed: ordered batch stopped at an earlier error")
 			}
 		}
-		
-		// Count extra fields (excluding _id and core fields)
-		extraFieldCount := 0
-		for key := range existingDoc {
-			if key != "_id" {
-				isCore := false
-				for _, coreField := range coreFields {
-					if key == coreField {
-						isCore = true
-						break
-					}
-				}
-				if !isCore {
-					extraFieldCount++
-				}
+		result.Failed = len(result.Errors)
+	}
+
+	return result, nil
+}
+
+// FindRecords loads the stored documents matching records on key, keyed by
+// key.Value. Records with no stored document are absent from the map. When
+// index is not nil, the records carry normalized Numbers and are resolved
+// to documents through the index instead of by exact match.
+func (m *MongoDB) FindRecords(collectionName string, key models.MatchKey, records []models.ProductRecord, index *KeyIndex) (map[string]models.ProductRecord, error) {
+	found := make(map[string]models.ProductRecord, len(records))
+	if len(records) == 0 {
+		return found, nil
+	}
+
+	var filter bson.M
+	if len(key) == 1 {
+		values := make([]string, len(records))
+		for i, record := range records {
+			values[i], _ = record.Field(key[0])
+		}
+		filter = bson.M{key[0]: bson.M{"$in": values}}
+	} else {
+		clauses := make([]bson.M, len(records))
+		for i, record := range records {
+			clauses[i] = keyFilter(key, record)
+		}
+		filter = bson.M{"$or": clauses}
+	}
+
+	// keyOf maps a stored document back to the key it was requested by
+	keyOf := key.Value
+
+	if index != nil {
+		ids := make([]interface{}, 0, len(records))
+		keyByID := make(map[interface{}]string, len(records))
+		for _, record := range records {
+			value := key.Value(record)
+			if id, exists := index.ids[value]; exists {
+				ids = append(ids, id)
+				keyByID[id] = value
 			}
 		}
-		
-		log.Printf("Updated existing record with Number: %s (preserved %d extra fields)", 
-			record.Number, extraFieldCount)
-	} else if err == mongo.ErrNoDocuments {
-		// Record doesn't exist - use new record as-is
-		newDocBytes, err := bson.Marshal(record)
+		if len(ids) == 0 {
+			return found, nil
+		}
+		filter = bson.M{"_id": bson.M{"$in": ids}}
+		keyOf = func(record models.ProductRecord) string { return keyByID[record.ID] }
+	}
+
+	collection := m.Database.Collection(collectionName)
+	ctx, cancel := m.context(2*time.Minute)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find records: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		record, err := decodeRecord(cursor.Current)
 		if err != nil {
-			return false, fmt.Errorf("failed to marshal new record: %w", err)
+			return nil, err
 		}
-		
-		if err := bson.Unmarshal(newDocBytes, &finalDoc); err != nil {
-			return false, fmt.Errorf("failed to unmarshal new record: %w", err)
+		found[keyOf(record)] = record
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("cursor error: %w", err)
+	}
+	return found, nil
+}
+
+// keyFilter matches the document with the same key fields as record
+func keyFilter(key models.MatchKey, record models.ProductRecord) bson.M {
+	filter := bson.M{}
+	for _, field := range key {
+		filter[field], _ = record.Field(field)
+	}
+	return filter
+}
+
+// KeyIndex maps normalized match keys to the _id of the stored document
+// they match. Documents saved before normalization was enabled may hold raw
+// Numbers such as "123" or 1.23457e+11, which an exact filter would miss.
+type KeyIndex struct {
+	ids map[string]interface{}
+}
+
+// LoadKeyIndex scans the key fields of every stored document and indexes
+// them by their key with the Number normalized. When several documents
+// share a normalized key, the first one found is used and the others are
+// reported. The whole index is held in memory for the import, one entry
+// per stored document.
+func (m *MongoDB) LoadKeyIndex(collectionName string, key models.MatchKey, normalize func(string) string) (*KeyIndex, error) {
+	collection := m.Database.Collection(collectionName)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	projection := bson.M{"_id": 1}
+	for _, field := range key {
+		projection[field] = 1
+	}
+	opts := options.Find().SetProjection(projection)
+	cursor, err := collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan stored keys: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	index := &KeyIndex{ids: make(map[string]interface{})}
+	collisions := 0
+	for cursor.Next(ctx) {
+		record, err := decodeRecord(cursor.Current)
+		if err != nil {
+			return nil, err
 		}
-		
-		log.Printf("Inserted new record with Number: %s", record.Number)
-	} else {
-		return false, fmt.Errorf("failed to check existing record with Number %s: %w", record.Number, err)
+		if record.ID == nil || !reflect.TypeOf(record.ID).Comparable() {
+			log.Printf("Warning: skipping document with %s: its _id cannot be used as a match key", key.Describe(record))
+			continue
+		}
+
+		raw := key.Describe(record)
+		record.Number = normalize(record.Number)
+		value := key.Value(record)
+		if _, exists := index.ids[value]; exists {
+			log.Printf("Warning: stored document with %s normalizes to %s, which already matches another document",
+				raw, key.Describe(record))
+			collisions++
+			continue
+		}
+		index.ids[value] = record.ID
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("cursor error: %w", err)
 	}
-	
-	// Replace entire document with merged data
-	opts := options.Replace().SetUpsert(true)
-	_, err = collection.ReplaceOne(ctx, filter, finalDoc, opts)
+
+	log.Printf("Indexed %d stored keys in '%s' for normalized matching (%d collisions)",
+		len(index.ids), collectionName, collisions)
+	return index, nil
+}
+
+// missingBatchSize bounds the number of _ids sent in a single $in filter
+const missingBatchSize = 1000
+
+// FindMissingRecords scans every stored document and returns those whose
+// match key is not in present. When normalize is not nil, the stored Number
+// is normalized before it is compared.
+func (m *MongoDB) FindMissingRecords(collectionName string, key models.MatchKey, present map[string]bool, normalize func(string) string) ([]models.ProductRecord, error) {
+	collection := m.Database.Collection(collectionName)
+	ctx, cancel := m.context(30*time.Minute)
+	defer cancel()
+
+	projection := bson.M{"_id": 1, "Archived": 1}
+	for _, field := range coreFields {
+		projection[field] = 1
+	}
+	opts := options.Find().SetProjection(projection)
+	cursor, err := collection.Find(ctx, bson.D{}, opts)
 	if err != nil {
-		return false, fmt.Errorf("failed to upsert record with Number %s: %w", record.Number, err)
+		return nil, fmt.Errorf("failed to scan stored keys: %w", err)
 	}
-	
-	return wasUpdate, nil
+	defer cursor.Close(ctx)
+
+	var missing []models.ProductRecord
+	for cursor.Next(ctx) {
+		record, err := decodeRecord(cursor.Current)
+		if err != nil {
+			return nil, err
+		}
+
+		lookup := record
+		if normalize != nil {
+			lookup.Number = normalize(lookup.Number)
+		}
+		if !present[key.Value(lookup)] {
+			missing = append(missing, record)
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("cursor error: %w", err)
+	}
+	return missing, nil
+}
+
+// ArchiveRecords flags the documents with the given _ids as archived and
+// returns how many were not archived before
+func (m *MongoDB) ArchiveRecords(collectionName string, ids []interface{}, archivedAt time.Time) (int, error) {
+	collection := m.Database.Collection(collectionName)
+	ctx, cancel := m.context(5*time.Minute)
+	defer cancel()
+
+	archived := 0
+	for start := 0; start < len(ids); start += missingBatchSize {
+		end := start + missingBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		filter := bson.M{"_id": bson.M{"$in": ids[start:end]}, "Archived": bson.M{"$ne": true}}
+		update := bson.M{"$set": bson.M{"Archived": true, "ArchivedAt": archivedAt}}
+		res, err := collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return archived, fmt.Errorf("failed to archive records: %w", err)
+		}
+		archived += int(res.ModifiedCount)
+	}
+	return archived, nil
+}
+
+// DeleteRecords removes the documents with the given _ids and returns how
+// many were deleted
+func (m *MongoDB) DeleteRecords(collectionName string, ids []interface{}) (int, error) {
+	collection := m.Database.Collection(collectionName)
+	ctx, cancel := m.context(5*time.Minute)
+	defer cancel()
+
+	deleted := 0
+	for start := 0; start < len(ids); start += missingBatchSize {
+		end := start + missingBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		res, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids[start:end]}})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete records: %w", err)
+		}
+		deleted += int(res.DeletedCount)
+	}
+	return deleted, nil
+}
+
+// decodeRecord decodes a stored document into a ProductRecord. Non-string
+// values in the core fields are formatted rather than rejected, and every
+// field other than _id, AutoSelect, the archive flags and the core fields is flattened into
+// Extra under its dotted path so it can be compared with extra CSV columns.
+func decodeRecord(raw bson.Raw) (models.ProductRecord, error) {
+	var record models.ProductRecord
+
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return record, fmt.Errorf("failed to decode record: %w", err)
+	}
+
+	record.Extra = make(map[string]string)
+	for _, elem := range doc {
+		switch {
+		case elem.Key == "_id":
+			record.ID = elem.Value
+		case elem.Key == "AutoSelect":
+			record.AutoSelect = formatValue(elem.Value)
+		case elem.Key == "Archived":
+			record.Archived = elem.Value == true
+		case elem.Key == "ArchivedAt":
+			// only meaningful while Archived is set
+		case isCoreField(elem.Key):
+			record.SetField(elem.Key, formatValue(elem.Value))
+		default:
+			flattenValue(record.Extra, elem.Key, elem.Value)
+		}
+	}
+	return record, nil
+}
+
+func formatValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func flattenValue(dst map[string]string, path string, value interface{}) {
+	if embedded, ok := value.(bson.D); ok {
+		for _, elem := range embedded {
+			flattenValue(dst, path+"."+elem.Key, elem.Value)
+		}
+		return
+	}
+	dst[path] = formatValue(value)
+}
+
+func isCoreField(field string) bool {
+	for _, core := range coreFields {
+		if field == core {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *MongoDB) ListCollections() ([]string, error) {
@@ -158,40 +465,44 @@ func (m *MongoDB) ListCollections() ([]string, error) {
 	return cursor, nil
 }
 
-func (m *MongoDB) BackupCollection(collectionName string, writer io.Writer, format string) error {
+// Extended JSON modes for JSON backups
+const (
+	// JSONCanonical keeps every BSON type, e.g. {"$numberLong": "42"}
+	JSONCanonical = "canonical"
+	// JSONRelaxed writes numbers and dates in a more readable form and
+	// only keeps the types plain JSON cannot express
+	JSONRelaxed = "relaxed"
+)
+
+// BackupCollection writes every document of a collection to writer and
+// returns the number of documents written. BSON backups hold the documents
+// exactly as stored; JSON backups hold one Extended JSON document per line
+// in the given mode.
+func (m *MongoDB) BackupCollection(collectionName string, writer io.Writer, format, jsonMode string) (int, error) {
 	collection := m.Database.Collection(collectionName)
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
 
 	cursor, err := collection.Find(ctx, bson.D{})
 	if err != nil {
-		return fmt.Errorf("failed to find documents: %w", err)
+		return 0, fmt.Errorf("failed to find documents: %w", err)
 	}
 	defer cursor.Close(ctx)
 
+	canonical := jsonMode != JSONRelaxed
 	count := 0
 	for cursor.Next(ctx) {
-		var doc bson.M
-		if err := cursor.Decode(&doc); err != nil {
-			return fmt.Errorf("failed to decode document: %w", err)
-		}
-
-		var data []byte
+		data := []byte(cursor.Current)
 		if format == "json" {
-			data, err = json.Marshal(doc)
+			data, err = bson.MarshalExtJSON(cursor.Current, canonical, false)
 			if err != nil {
-				return fmt.Errorf("failed to marshal to JSON: %w", err)
+				return 0, fmt.Errorf("failed to marshal to Extended JSON: %w", err)
 			}
 			data = append(data, '\n')
-		} else {
-			data, err = bson.Marshal(doc)
-			if err != nil {
-				return fmt.Errorf("failed to marshal to BSON: %w", err)
-			}
 		}
 
 		if _, err := writer.Write(data); err != nil {
-			return fmt.Errorf("failed to write backup data: %w", err)
+			return 0, fmt.Errorf("failed to write backup data: %w", err)
 		}
 		count++
 
@@ -201,13 +512,17 @@ func (m *MongoDB) BackupCollection(collectionName string, writer io.Writer, form
 	}
 
 	if err := cursor.Err(); err != nil {
-		return fmt.Errorf("cursor error: %w", err)
+		return 0, fmt.Errorf("cursor error: %w", err)
 	}
 
 	log.Printf("Backup completed: %d documents from collection '%s'", count, collectionName)
-	return nil
+	return count, nil
 }
 
+// RestoreCollection inserts the documents of a BSON or JSON backup into a
+// collection. JSON is parsed as Extended JSON in either mode, which also
+// accepts the plain JSON of older backups, so the original BSON types and
+// field order are restored.
 func (m *MongoDB) RestoreCollection(collectionName string, reader io.Reader, format string, dropExisting bool) error {
 	collection := m.Database.Collection(collectionName)
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
@@ -221,23 +536,37 @@ func (m *MongoDB) RestoreCollection(collectionName string, reader io.Reader, for
 
 	var documents []interface{}
 	const batchSize = 1000
+	total := 0
+
+	add := func(doc interface{}) error {
+		documents = append(documents, doc)
+		total++
+		if len(documents) < batchSize {
+			return nil
+		}
+		if err := m.insertBatch(collection, documents); err != nil {
+			return err
+		}
+		documents = documents[:0]
+		return nil
+	}
 
 	if format == "json" {
 		decoder := json.NewDecoder(reader)
 		for {
-			var doc bson.M
-			if err := decoder.Decode(&doc); err == io.EOF {
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err == io.EOF {
 				break
 			} else if err != nil {
 				return fmt.Errorf("failed to decode JSON: %w", err)
 			}
-			documents = append(documents, doc)
 
-			if len(documents) >= batchSize {
-				if err := m.insertBatch(collection, documents); err != nil {
-					return err
-				}
-				documents = documents[:0]
+			var doc bson.D
+			if err := bson.UnmarshalExtJSON(raw, false, &doc); err != nil {
+				return fmt.Errorf("failed to parse Extended JSON document %d: %w", total+1, err)
+			}
+			if err := add(doc); err != nil {
+				return err
 			}
 		}
 	} else {
@@ -246,36 +575,39 @@ func (m *MongoDB) RestoreCollection(collectionName string, reader io.Reader, for
 
 		for {
 			n, err := reader.Read(buffer)
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return fmt.Errorf("failed to read BSON data: %w", err)
-			}
-
 			docBuffer = append(docBuffer, buffer[:n]...)
 
 			for len(docBuffer) >= 4 {
 				docSize := int(docBuffer[0]) | int(docBuffer[1])<<8 | int(docBuffer[2])<<16 | int(docBuffer[3])<<24
+				if docSize < 5 {
+					return fmt.Errorf("corrupt BSON data: invalid document length %d", docSize)
+				}
 				if len(docBuffer) < docSize {
 					break
 				}
 
-				var doc bson.M
-				if err := bson.Unmarshal(docBuffer[:docSize], &doc); err != nil {
+				// Documents are inserted as stored, keeping every type
+				doc := bson.Raw(append([]byte(nil), docBuffer[:docSize]...))
+				if err := doc.Validate(); err != nil {
 					return fmt.Errorf("failed to unmarshal BSON: %w", err)
 				}
-
-				documents = append(documents, doc)
 				docBuffer = docBuffer[docSize:]
 
-				if len(documents) >= batchSize {
-					if err := m.insertBatch(collection, documents); err != nil {
-						return err
-					}
-					documents = documents[:0]
+				if err := add(doc); err != nil {
+					return err
 				}
 			}
+
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read BSON data: %w", err)
+			}
+		}
+
+		if len(docBuffer) > 0 {
+			return fmt.Errorf("corrupt BSON data: %d trailing bytes", len(docBuffer))
 		}
 	}
 
@@ -285,10 +617,16 @@ func (m *MongoDB) RestoreCollection(collectionName string, reader io.Reader, for
 		}
 	}
 
-	log.Printf("Restore completed: imported documents to collection '%s'", collectionName)
+	log.Printf("Restore completed: imported %d documents to collection '%s'", total, collectionName)
 	return nil
 }
 
+// InsertDocuments inserts a batch of documents, as read from a backup,
+// into a collection
+func (m *MongoDB) InsertDocuments(collectionName string, documents []interface{}) error {
+	return m.insertBatch(m.Database.Collection(collectionName), documents)
+}
+
 func (m *MongoDB) insertBatch(collection *mongo.Collection, documents []interface{}) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// SupportsTransactions reports whether the server is a replica set member
+// or a mongos router; standalone servers cannot run transactions
+func (m *MongoDB) SupportsTransactions() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := m.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		// Servers before 4.4.2 only know the legacy command name
+		err = m.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check server topology: %w", err)
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+// BeginTransaction starts a transaction that every later import read and
+// write joins until it is committed or aborted
+func (m *MongoDB) BeginTransaction() error {
+	if m.session != nil {
+		return fmt.Errorf("a transaction is already open")
+	}
+
+	session, err := m.Client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+
+	opts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.Majority())
+	if err := session.StartTransaction(opts); err != nil {
+		session.EndSession(context.Background())
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	m.session = session
+	return nil
+}
+
+// CommitTransaction commits the open transaction
+func (m *MongoDB) CommitTransaction() error {
+	if m.session == nil {
+		return fmt.Errorf("no transaction is open")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	defer m.endSession()
+
+	if err := m.session.CommitTransaction(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// AbortTransaction rolls back the open transaction, if any
+func (m *MongoDB) AbortTransaction() error {
+	if m.session == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer m.endSession()
+
+	if err := m.session.AbortTransaction(ctx); err != nil {
+		return fmt.Errorf("failed to abort transaction: %w", err)
+	}
+	return nil
+}
+
+// InTransaction reports whether a transaction is open
+func (m *MongoDB) InTransaction() bool {
+	return m.session != nil
+}
+
+func (m *MongoDB) endSession() {
+	m.session.EndSession(context.Background())
+	m.session = nil
+}
+
+// context returns a context with the given timeout that joins the open
+// transaction, if any
+func (m *MongoDB) context(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	if m.session != nil {
+		return mongo.NewSessionContext(ctx, m.session), cancel
+	}
+	return ctx, cancel
+}
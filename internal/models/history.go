@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// Changes recorded in the history for documents a --sync import found
+// missing from the file
+const (
+	ChangeArchive ChangeType = "archive"
+	ChangeDelete  ChangeType = "delete"
+)
+
+// Import run statuses
+const (
+	RunRunning   = "running"
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+)
+
+// ImportCounts counts the outcome of every row in an import run
+type ImportCounts struct {
+	Total      int `bson:"Total"`
+	Inserted   int `bson:"Inserted"`
+	Updated    int `bson:"Updated"`
+	Unchanged  int `bson:"Unchanged"`
+	Skipped    int `bson:"Skipped"`
+	Duplicates int `bson:"Duplicates"`
+	Failed     int `bson:"Failed"`
+
+	// Stored documents not found in the file, set by --sync
+	Missing  int `bson:"Missing"`
+	Archived int `bson:"Archived"`
+	Deleted  int `bson:"Deleted"`
+}
+
+// ImportRun is the audit record of a single import
+type ImportRun struct {
+	ID         interface{} `bson:"_id,omitempty"`
+	File       string      `bson:"File"`
+	SHA256     string      `bson:"SHA256"`
+	Database   string      `bson:"Database"`
+	Collection string      `bson:"Collection"`
+	User       string      `bson:"User"`
+	StartedAt  time.Time   `bson:"StartedAt"`
+	FinishedAt *time.Time  `bson:"FinishedAt,omitempty"`
+	Status     string      `bson:"Status"`
+	Error      string      `bson:"Error,omitempty"`
+	// ResumedAfter is the checkpoint row a --resume run continued from
+	ResumedAfter int          `bson:"ResumedAfter,omitempty"`
+	Counts       ImportCounts `bson:"Counts"`
+}
+
+// CoreValues holds the four CSV-owned fields of a document
+type CoreValues struct {
+	Product            string `bson:"Product"`
+	Number             string `bson:"Number"`
+	Description        string `bson:"Description"`
+	DisclaimerVerbiage string `bson:"DisclaimerVerbiage"`
+}
+
+// Core returns the CSV-owned fields of r
+func (r ProductRecord) Core() *CoreValues {
+	return &CoreValues{
+		Product:            r.Product,
+		Number:             r.Number,
+		Description:        r.Description,
+		DisclaimerVerbiage: r.DisclaimerVerbiage,
+	}
+}
+
+// RecordHistory is the change log entry for one document changed by an
+// import. Before is empty for inserts and After for deletes.
+type RecordHistory struct {
+	RunID      interface{} `bson:"RunID"`
+	DocumentID interface{} `bson:"DocumentID"`
+	Collection string      `bson:"Collection"`
+	Number     string      `bson:"Number"`
+	Change     ChangeType  `bson:"Change"`
+	Before     *CoreValues `bson:"Before,omitempty"`
+	After      *CoreValues `bson:"After,omitempty"`
+	User       string      `bson:"User"`
+	ChangedAt  time.Time   `bson:"ChangedAt"`
+}
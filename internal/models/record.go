@@ -1,11 +1,219 @@
 package models
 
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
 type ProductRecord struct {
+	// ID is the _id of the stored document this record was matched to
+	ID                interface{} `bson:"_id,omitempty" csv:"-"`
 	Product           string `csv:"product" bson:"Product"`
 	Number            string `csv:"number" bson:"Number"`
 	Description       string `csv:"description" bson:"Description"`
 	DisclaimerVerbiage string `csv:"verbal disclaimer" bson:"DisclaimerVerbiage"`
 	AutoSelect        string `bson:"AutoSelect"`
+	// Archived is set on stored documents that a --sync import found
+	// missing from the file
+	Archived bool `bson:"Archived,omitempty" csv:"-"`
+	// Extra holds CSV columns beyond the core fields, keyed by their
+	// dotted document path (e.g. "Region" or "Attributes.Region")
+	Extra map[string]string `bson:"-" csv:"-"`
+}
+
+// MappableFields are the ProductRecord BSON fields that can be read from a
+// CSV column, in document order
+var MappableFields = []string{"Product", "Number", "Description", "DisclaimerVerbiage", "AutoSelect"}
+
+// matchableFields are the MappableFields a match key may use. AutoSelect is
+// left out because it is computed during the import and may be kept from
+// the stored document.
+var matchableFields = []string{"Product", "Number", "Description", "DisclaimerVerbiage"}
+
+// IsMappableField reports whether field is one of MappableFields
+func IsMappableField(field string) bool {
+	for _, name := range MappableFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+func isMatchableField(field string) bool {
+	for _, name := range matchableFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// SetField sets a field by its BSON name and reports whether it exists
+func (r *ProductRecord) SetField(field, value string) bool {
+	switch field {
+	case "Product":
+		r.Product = value
+	case "Number":
+		r.Number = value
+	case "Description":
+		r.Description = value
+	case "DisclaimerVerbiage":
+		r.DisclaimerVerbiage = value
+	case "AutoSelect":
+		r.AutoSelect = value
+	default:
+		return false
+	}
+	return true
+}
+
+// Field returns a field by its BSON name, looking in Extra for anything that
+// is not a fixed field, and reports whether it exists
+func (r ProductRecord) Field(field string) (string, bool) {
+	switch field {
+	case "Product":
+		return r.Product, true
+	case "Number":
+		return r.Number, true
+	case "Description":
+		return r.Description, true
+	case "DisclaimerVerbiage":
+		return r.DisclaimerVerbiage, true
+	case "AutoSelect":
+		return r.AutoSelect, true
+	}
+	value, exists := r.Extra[field]
+	return value, exists
+}
+
+// MatchKey lists the fields that together identify a stored document
+type MatchKey []string
+
+// DefaultMatchKey matches records on Number alone
+func DefaultMatchKey() MatchKey {
+	return MatchKey{"Number"}
+}
+
+// ParseMatchKey parses a comma-separated list of field names such as
+// "Number,Product"
+func ParseMatchKey(value string) (MatchKey, error) {
+	var key MatchKey
+	for _, field := range strings.Split(value, ",") {
+		key = append(key, strings.TrimSpace(field))
+	}
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Validate checks that the key is made of distinct mappable fields
+func (k MatchKey) Validate() error {
+	if len(k) == 0 {
+		return fmt.Errorf("match key has no fields")
+	}
+	seen := make(map[string]bool)
+	for _, field := range k {
+		if !isMatchableField(field) {
+			return fmt.Errorf("unknown match key field %q (use %s)", field, strings.Join(matchableFields, ", "))
+		}
+		if seen[field] {
+			return fmt.Errorf("match key field %q is listed twice", field)
+		}
+		seen[field] = true
+	}
+	return nil
+}
+
+// Contains reports whether field is part of the key
+func (k MatchKey) Contains(field string) bool {
+	for _, name := range k {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns the key of record as a single comparable string
+func (k MatchKey) Value(record ProductRecord) string {
+	values := make([]string, len(k))
+	for i, field := range k {
+		values[i], _ = record.Field(field)
+	}
+	return strings.Join(values, "\x1f")
+}
+
+// Describe formats the key of record for log output
+func (k MatchKey) Describe(record ProductRecord) string {
+	parts := make([]string, len(k))
+	for i, field := range k {
+		value, _ := record.Field(field)
+		parts[i] = fmt.Sprintf("%s %s", field, value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ChangeType describes what an import does with a single CSV row
+type ChangeType string
+
+const (
+	ChangeInsert    ChangeType = "insert"
+	ChangeUpdate    ChangeType = "update"
+	ChangeUnchanged ChangeType = "unchanged"
+	ChangeSkipped   ChangeType = "skipped"
+)
+
+// FieldChange is a single field that differs between a stored record and
+// the incoming CSV row
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Diff returns the CSV-owned fields whose value in r differs from existing,
+// including any extra columns carried by r. A match key field only differs
+// when the stored value matched after normalization. An archived document
+// differs from every incoming row, which restores it. AutoSelect only
+// differs when r carries a value, as an empty AutoSelect is never written
+// over a stored one.
+func (r ProductRecord) Diff(existing ProductRecord) []FieldChange {
+	var changes []FieldChange
+	if r.Number != existing.Number {
+		changes = append(changes, FieldChange{Field: "Number", Old: existing.Number, New: r.Number})
+	}
+	if r.Product != existing.Product {
+		changes = append(changes, FieldChange{Field: "Product", Old: existing.Product, New: r.Product})
+	}
+	if r.Description != existing.Description {
+		changes = append(changes, FieldChange{Field: "Description", Old: existing.Description, New: r.Description})
+	}
+	if r.DisclaimerVerbiage != existing.DisclaimerVerbiage {
+		changes = append(changes, FieldChange{Field: "DisclaimerVerbiage", Old: existing.DisclaimerVerbiage, New: r.DisclaimerVerbiage})
+	}
+	if r.AutoSelect != "" && r.AutoSelect != existing.AutoSelect {
+		changes = append(changes, FieldChange{Field: "AutoSelect", Old: existing.AutoSelect, New: r.AutoSelect})
+	}
+	if r.Archived != existing.Archived {
+		changes = append(changes, FieldChange{Field: "Archived", Old: strconv.FormatBool(existing.Archived), New: strconv.FormatBool(r.Archived)})
+	}
+
+	paths := make([]string, 0, len(r.Extra))
+	for path := range r.Extra {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		old, exists := existing.Extra[path]
+		if !exists || old != r.Extra[path] {
+			changes = append(changes, FieldChange{Field: path, Old: old, New: r.Extra[path]})
+		}
+	}
+	return changes
 }
 
 // Legacy Record struct for backward compatibility with existing backup/restore
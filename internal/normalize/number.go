@@ -0,0 +1,94 @@
+package normalize
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Case folding modes
+const (
+	CaseUpper = "upper"
+	CaseLower = "lower"
+)
+
+var scientificPattern = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?[eE][+-]?[0-9]+$`)
+
+// Number repairs product numbers mangled by Excel so the same product always
+// produces the same match key. Steps run in the order of the fields below;
+// the zero value leaves numbers untouched apart from trimming.
+type Number struct {
+	// ExpandScientific turns values like 1.23457E+11 back into plain digits
+	ExpandScientific bool
+	// StripChars lists separator characters to remove; a space in the list
+	// removes every kind of whitespace
+	StripChars string
+	// Case folds letters to upper or lower case
+	Case string
+	// PadWidth left-pads all-digit numbers with zeros to this width
+	PadWidth int
+}
+
+// Validate checks the configured options
+func (n Number) Validate() error {
+	if n.Case != "" && n.Case != CaseUpper && n.Case != CaseLower {
+		return fmt.Errorf("invalid case: %s. Use 'upper' or 'lower'", n.Case)
+	}
+	if n.PadWidth < 0 {
+		return fmt.Errorf("pad width cannot be negative")
+	}
+	return nil
+}
+
+// Enabled reports whether any normalization step is configured
+func (n Number) Enabled() bool {
+	return n.ExpandScientific || n.StripChars != "" || n.Case != "" || n.PadWidth > 0
+}
+
+// Apply normalizes a single number
+func (n Number) Apply(value string) string {
+	value = strings.TrimSpace(value)
+
+	if n.ExpandScientific && scientificPattern.MatchString(value) {
+		if f, ok := new(big.Float).SetPrec(256).SetString(value); ok && f.IsInt() {
+			value = f.Text('f', 0)
+		}
+	}
+
+	if n.StripChars != "" {
+		stripSpace := strings.ContainsRune(n.StripChars, ' ')
+		value = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(n.StripChars, r) || (stripSpace && unicode.IsSpace(r)) {
+				return -1
+			}
+			return r
+		}, value)
+	}
+
+	switch n.Case {
+	case CaseUpper:
+		value = strings.ToUpper(value)
+	case CaseLower:
+		value = strings.ToLower(value)
+	}
+
+	if n.PadWidth > 0 && len(value) < n.PadWidth && isDigits(value) {
+		value = strings.Repeat("0", n.PadWidth-len(value)) + value
+	}
+
+	return value
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package normalize
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNumberApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		number Number
+		value  string
+		want   string
+	}{
+		{"zero value trims", Number{}, "  00123 ", "00123"},
+		{"zero value keeps scientific", Number{}, "1.23457E+11", "1.23457E+11"},
+		{"scientific expanded", Number{ExpandScientific: true}, "1.23457E+11", "123457000000"},
+		{"lower case exponent", Number{ExpandScientific: true}, "4.5e3", "4500"},
+		{"integer mantissa", Number{ExpandScientific: true}, "12E2", "1200"},
+		{"fractional result kept", Number{ExpandScientific: true}, "1.5E-3", "1.5E-3"},
+		{"not scientific", Number{ExpandScientific: true}, "AB1E5", "AB1E5"},
+		{"strip separators", Number{StripChars: "-."}, "12-34.56", "123456"},
+		{"space strips all whitespace", Number{StripChars: " "}, "12 34\t56 78", "12345678"},
+		{"upper case", Number{Case: CaseUpper}, "ab-12c", "AB-12C"},
+		{"lower case", Number{Case: CaseLower}, "AB-12C", "ab-12c"},
+		{"zero pad digits", Number{PadWidth: 8}, "12345", "00012345"},
+		{"zero pad leaves letters", Number{PadWidth: 8}, "A12345", "A12345"},
+		{"zero pad leaves long numbers", Number{PadWidth: 3}, "12345", "12345"},
+		{"zero pad leaves empty", Number{PadWidth: 3}, "", ""},
+		{"pad after strip", Number{StripChars: "-", PadWidth: 6}, "12-3", "000123"},
+		{"pad after expand", Number{ExpandScientific: true, PadWidth: 14}, "1.23457E+11", "00123457000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.number.Apply(tt.value); got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNumberValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		number  Number
+		wantErr string
+	}{
+		{"zero value", Number{}, ""},
+		{"all options", Number{ExpandScientific: true, StripChars: " -", Case: CaseUpper, PadWidth: 10}, ""},
+		{"invalid case", Number{Case: "title"}, "invalid case: title"},
+		{"negative pad width", Number{PadWidth: -1}, "pad width cannot be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.number.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
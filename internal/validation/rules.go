@@ -0,0 +1,210 @@
+package validation
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"unicode/utf8"
+
+	"excelDisclaimer/internal/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Rule is a set of checks applied to a single ProductRecord field. Field is
+// a BSON field name or the dotted path of a kept extra column.
+type Rule struct {
+	Field        string   `yaml:"field"`
+	Severity     Severity `yaml:"severity"`
+	Required     bool     `yaml:"required"`
+	Pattern      string   `yaml:"pattern"`
+	MinLength    int      `yaml:"min_length"`
+	MaxLength    int      `yaml:"max_length"`
+	AllowedChars string   `yaml:"allowed_chars"`
+
+	pattern      *regexp.Regexp
+	allowedChars *regexp.Regexp
+}
+
+// Violation is a single failed check on a single CSV row
+type Violation struct {
+	Row      int
+	Field    string
+	Check    string
+	Severity Severity
+	Message  string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("row %d: [%s] %s %s: %s", v.Row, v.Severity, v.Field, v.Check, v.Message)
+}
+
+// Validator applies a list of rules to records
+type Validator struct {
+	rules []*Rule
+}
+
+// NewValidator compiles rules. Every match key field is always required
+// with error severity unless a rule already requires it.
+func NewValidator(rules []Rule, key models.MatchKey) (*Validator, error) {
+	v := &Validator{}
+	keyRequired := make(map[string]bool)
+	for i := range rules {
+		rule := rules[i]
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i+1, rule.Field, err)
+		}
+		if rule.Required && rule.Severity == SeverityError {
+			keyRequired[rule.Field] = true
+		}
+		v.rules = append(v.rules, &rule)
+	}
+
+	var keyRules []*Rule
+	for _, field := range key {
+		if !keyRequired[field] {
+			keyRules = append(keyRules, &Rule{Field: field, Severity: SeverityError, Required: true})
+		}
+	}
+	v.rules = append(keyRules, v.rules...)
+	return v, nil
+}
+
+// DefaultValidator reproduces the built-in checks: rows with an empty match
+// key field are rejected and rows without a Product produce a warning
+func DefaultValidator(key models.MatchKey) *Validator {
+	var rules []Rule
+	if !key.Contains("Product") {
+		rules = append(rules, Rule{Field: "Product", Severity: SeverityWarning, Required: true})
+	}
+	v, _ := NewValidator(rules, key)
+	return v
+}
+
+// LoadRules reads a YAML rules file of the form
+//
+//	rules:
+//	  - field: Number
+//	    pattern: '^[0-9]{6}$'
+//	  - field: DisclaimerVerbiage
+//	    max_length: 500
+//	    severity: warning
+func LoadRules(filename string, key models.MatchKey) (*Validator, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var config struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file: %w", err)
+	}
+
+	v, err := NewValidator(config.Rules, key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", filename, err)
+	}
+	return v, nil
+}
+
+func (r *Rule) compile() error {
+	if r.Field == "" {
+		return fmt.Errorf("field is required")
+	}
+
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityError
+	case SeverityError, SeverityWarning:
+	default:
+		return fmt.Errorf("invalid severity '%s'. Use 'error' or 'warning'", r.Severity)
+	}
+
+	if r.MinLength < 0 || r.MaxLength < 0 {
+		return fmt.Errorf("lengths cannot be negative")
+	}
+	if r.MaxLength > 0 && r.MinLength > r.MaxLength {
+		return fmt.Errorf("min_length %d is greater than max_length %d", r.MinLength, r.MaxLength)
+	}
+
+	if r.Pattern != "" {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		r.pattern = pattern
+	}
+
+	// Allowed characters are written as the body of a character class,
+	// e.g. "A-Za-z0-9 .,-"
+	if r.AllowedChars != "" {
+		allowed, err := regexp.Compile("^[" + r.AllowedChars + "]*$")
+		if err != nil {
+			return fmt.Errorf("invalid allowed_chars: %w", err)
+		}
+		r.allowedChars = allowed
+	}
+
+	return nil
+}
+
+// Validate checks record, found on the given CSV row, against every rule.
+// Checks other than required are skipped for empty values.
+func (v *Validator) Validate(row int, record models.ProductRecord) []Violation {
+	var violations []Violation
+	for _, rule := range v.rules {
+		value, _ := record.Field(rule.Field)
+		violation := func(check, message string) {
+			violations = append(violations, Violation{
+				Row:      row,
+				Field:    rule.Field,
+				Check:    check,
+				Severity: rule.Severity,
+				Message:  message,
+			})
+		}
+
+		if value == "" {
+			if rule.Required {
+				violation("required", "value is empty")
+			}
+			continue
+		}
+
+		if rule.pattern != nil && !rule.pattern.MatchString(value) {
+			violation("pattern", fmt.Sprintf("%q does not match %s", value, rule.Pattern))
+		}
+
+		length := utf8.RuneCountInString(value)
+		if rule.MinLength > 0 && length < rule.MinLength {
+			violation("min_length", fmt.Sprintf("length %d is below %d", length, rule.MinLength))
+		}
+		if rule.MaxLength > 0 && length > rule.MaxLength {
+			violation("max_length", fmt.Sprintf("length %d is above %d", length, rule.MaxLength))
+		}
+
+		if rule.allowedChars != nil && !rule.allowedChars.MatchString(value) {
+			violation("allowed_chars", fmt.Sprintf("%q contains characters outside [%s]", value, rule.AllowedChars))
+		}
+	}
+	return violations
+}
+
+// HasErrors reports whether any violation has error severity
+func HasErrors(violations []Violation) bool {
+	for _, violation := range violations {
+		if violation.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
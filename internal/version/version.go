@@ -0,0 +1,6 @@
+package version
+
+// Version identifies the build. Release builds set it with
+//
+//	go build -ldflags "-X excelDisclaimer/internal/version.Version=1.2.0"
+var Version = "dev"